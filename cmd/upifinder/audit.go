@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+var auditCommand = &cli.Command{
+	Usage: "audit [-d] [-s] [-e] [-year] [-doy] [-u] [-c] [-k] [-seq64] <archive,...>",
+	Short: "report the files, gaps and corrupted files of each UPI",
+	Run:   runAudit,
+	Desc: `"audit" traverse the Hadock archive once and gives for each UPI the number of
+files, uniq files, invalid files, corrupted files and missing files with a
+verdict about the health of the UPI.
+
+A file is corrupted when its header can not be read or has an unknown format
+(see the "digest" command). Only the files found on the filesystem are read:
+the files found in a zip, tar or lst file are never corrupted.
+
+The verdict is the first of:
+
+  corrupted  at least one file is corrupted
+  missing    at least one file is missing
+  invalid    at least one file is invalid
+  ok         otherwise
+
+The period of time is selected with the same rules as the "walk" command.
+
+Options:
+
+  -u UPI     only count files for the given UPI
+  -s START   only count files created after START
+  -e END     only count files created before END
+  -d DAYS    only count files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only count files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
+  -c         print the results as csv
+  -k         keep invalid files in the count of gaps
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)`,
+}
+
+func runAudit(cmd *cli.Command, args []string) error {
+	var start, end When
+	cmd.Flag.Var(&start, "s", "start")
+	cmd.Flag.Var(&end, "e", "end")
+	upi := cmd.Flag.String("u", "", "upi")
+	period := cmd.Flag.Int("d", 0, "period")
+	year := cmd.Flag.Int("year", 0, "year")
+	var days DayList
+	cmd.Flag.Var(&days, "doy", "days of year")
+	csv := cmd.Flag.Bool("c", false, "csv")
+	keep := cmd.Flag.Bool("k", false, "keep invalid files")
+	long := cmd.Flag.Bool("seq64", false, "64 bits sequence")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+
+	if cmd.Flag.NArg() == 0 {
+		cmd.Help()
+	}
+
+	paths, err := selectPaths(cmd.Flag.Args(), *period, start.Time, end.Time, *year, days)
+	if err != nil {
+		return err
+	}
+	queue, as := auditFiles(walkFiles(paths, scanOptions{
+		UPI:      *upi,
+		Parallel: 1,
+		Buffer:   DefaultBuffer,
+		Parse:    parseOptions{Long: *long},
+	}))
+	for _, g := range checkFiles(queue, 0, *keep, 0, byUPI) {
+		if a, ok := as[g.UPI]; ok {
+			a.MissingFiles += g.Count()
+		}
+	}
+	if len(as) > 0 {
+		reportAuditResults(os.Stdout, sortAudits(as), *csv)
+	}
+	return nil
+}
+
+// Audit is the result of the audit of a UPI.
+type Audit struct {
+	*Coze
+	// CorruptedFiles is the number of files with an unreadable header.
+	CorruptedFiles uint64
+	// MissingFiles is the number of files missing in the gaps of the UPI.
+	MissingFiles uint64
+}
+
+func (a *Audit) Verdict() string {
+	switch {
+	case a.CorruptedFiles > 0:
+		return "corrupted"
+	case a.MissingFiles > 0:
+		return "missing"
+	case a.Invalid > 0:
+		return "invalid"
+	default:
+		return "ok"
+	}
+}
+
+// auditFiles counts the files of queue by UPI and checks the header of the
+// files found on the filesystem before forwarding them. The returned map can be safely read once the returned
+// channel is closed.
+func auditFiles(queue <-chan *File) (<-chan *File, map[string]*Audit) {
+	q := make(chan *File)
+	as := make(map[string]*Audit)
+	go func() {
+		defer close(q)
+		for f := range queue {
+			k := f.String()
+			a, ok := as[k]
+			if !ok {
+				a = &Audit{
+					Coze: &Coze{
+						UPI:    k,
+						First:  f.Sequence,
+						Last:   f.Sequence,
+						Starts: f.AcqTime,
+						Ends:   f.AcqTime,
+					},
+				}
+				as[k] = a
+			}
+			a.Update(f)
+			if f.Provenance == ProvLoose && isCorrupted(f.Path) {
+				a.CorruptedFiles++
+			}
+			q <- f
+		}
+	}()
+	return q, as
+}
+
+var knownFormats = [][]byte{
+	MMA, CORR, SYNC, RAW, Y800, Y16B, Y16L, I420, YUY2, RGB, JPEG, PNG, H264, SVS, TIFF,
+}
+
+// isCorrupted reports whether the header of the file p can not be read or has
+// an unknown format. A file that can not be opened is not corrupted.
+func isCorrupted(p string) bool {
+	r, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return true
+	}
+	for _, f := range knownFormats {
+		if bytes.Equal(magic, f) {
+			_, err := io.CopyN(ioutil.Discard, r, skipBytes(magic))
+			return err != nil
+		}
+	}
+	return true
+}
+
+func sortAudits(as map[string]*Audit) []*Audit {
+	vs := make([]*Audit, 0, len(as))
+	for _, a := range as {
+		vs = append(vs, a)
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		return vs[i].UPI < vs[j].UPI
+	})
+	return vs
+}
+
+func reportAuditResults(w io.Writer, as []*Audit, csv bool) {
+	line := Line(csv)
+	for _, a := range as {
+		line.AppendString(Transform(a.UPI), 24, linewriter.AlignLeft)
+		line.AppendUint(a.Count, 8, linewriter.AlignRight)
+		line.AppendUint(a.Uniq, 8, linewriter.AlignRight)
+		line.AppendUint(a.Invalid, 8, linewriter.AlignRight)
+		line.AppendUint(a.CorruptedFiles, 8, linewriter.AlignRight)
+		line.AppendUint(a.MissingFiles, 8, linewriter.AlignRight)
+		line.AppendTime(a.Starts, time.RFC3339, linewriter.AlignRight)
+		line.AppendTime(a.Ends, time.RFC3339, linewriter.AlignRight)
+		line.AppendString(a.Verdict(), 9, linewriter.AlignLeft)
+
+		io.Copy(w, line)
+	}
+}
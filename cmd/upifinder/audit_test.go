@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditFiles(t *testing.T) {
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.dat"), filepath.Join(dir, "bad.dat")
+	if err := ioutil.WriteFile(good, testData(1, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bad, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var fs []*File
+	for i, d := range []struct {
+		Path       string
+		Provenance string
+	}{
+		{Path: good, Provenance: ProvLoose},
+		{Path: bad, Provenance: ProvLoose},
+		// the members of a tar or lst file are not read from the filesystem
+		// even if a file exists with the same path.
+		{Path: bad, Provenance: ProvTar},
+		{Path: bad, Provenance: ProvList},
+	} {
+		f := testFile("XYZ", uint64(i+1), false)
+		f.Path, f.Provenance = d.Path, d.Provenance
+		fs = append(fs, f)
+	}
+	queue, as := auditFiles(feedFiles(fs))
+	for range queue {
+	}
+	a, ok := as[fs[0].String()]
+	if !ok || len(as) != 1 {
+		t.Fatalf("want %s audited alone, got %d upis", fs[0], len(as))
+	}
+	if a.Count != 4 || a.CorruptedFiles != 1 {
+		t.Errorf("want 4 files with 1 corrupted, got %d files with %d corrupted", a.Count, a.CorruptedFiles)
+	}
+}
+
+func TestAuditVerdict(t *testing.T) {
+	data := []struct {
+		Audit Audit
+		Want  string
+	}{
+		{Audit: Audit{Coze: &Coze{}}, Want: "ok"},
+		{Audit: Audit{Coze: &Coze{Invalid: 1}}, Want: "invalid"},
+		{Audit: Audit{Coze: &Coze{Invalid: 1}, MissingFiles: 2}, Want: "missing"},
+		{Audit: Audit{Coze: &Coze{Invalid: 1}, MissingFiles: 2, CorruptedFiles: 1}, Want: "corrupted"},
+	}
+	for i, d := range data {
+		if got := d.Audit.Verdict(); got != d.Want {
+			t.Errorf("%d: want %s, got %s", i, d.Want, got)
+		}
+	}
+}
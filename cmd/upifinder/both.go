@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/midbel/cli"
+)
+
+var bothCommand = &cli.Command{
+	Usage: "both [-d] [-s] [-e] [-year] [-doy] [-u] [-i] [-c] [-header] [-k] [-z] [-tz] [-seq64] [-logfile] [-config] <archive,...>",
+	Alias: []string{"report-both"},
+	Short: "provide the number of files and the gaps of files in one walk of the archive",
+	Run:   runBoth,
+	Desc: `"both" (report-both) traverse the Hadock archive only once and print the results
+of "walk" followed by the results of "check-upi" (by UPI) for the same files.
+
+The period of time is selected with the same rules as the "walk" command.
+
+Options:
+
+  -u UPI     only count files for the given UPI
+  -buffer N  number of files found in advance of their processing (default 1024)
+  -config FILE  read the options not given on the command line from FILE (see
+             configuration file in README)
+  -s START   only count files created after START
+  -e END     only count files created before END
+  -d DAYS    only count files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only count files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
+  -i TIME    only consider gap with at least TIME duration
+  -c         print the results as csv
+  -header    print the names of the columns as first row of each csv (default
+             true, disabled with -header=false)
+  -k         keep invalid files in the count of missing files and of gaps
+  -z         discard UPI that have no missing files
+  -tz ZONE   print the times in the timezone ZONE (IANA name, eg Europe/Brussels)
+             instead of UTC
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)
+  -logfile FILE  also write the results to FILE (reopened on SIGHUP)`,
+}
+
+// bothConfig holds the options of both given on the command line or in the
+// configuration file (see -config).
+type bothConfig struct {
+	Start, End When
+	UPI        string
+	Buffer     int
+	Config     string
+	Period     int
+	Year       int
+	Days       DayList
+	Interval   time.Duration
+	CSV        bool
+	Header     bool
+	Keep       bool
+	Zero       bool
+	Zone       string
+	Long       bool
+	Logfile    string
+}
+
+// newBothConfig defines the flags of both in set.
+func newBothConfig(set *flag.FlagSet) *bothConfig {
+	var c bothConfig
+	set.Var(&c.Start, "s", "start")
+	set.Var(&c.End, "e", "end")
+	set.StringVar(&c.UPI, "u", "", "upi")
+	set.IntVar(&c.Buffer, "buffer", DefaultBuffer, "buffer")
+	set.StringVar(&c.Config, "config", "", "config file")
+	set.IntVar(&c.Period, "d", 0, "period")
+	set.IntVar(&c.Year, "year", 0, "year")
+	set.Var(&c.Days, "doy", "days of year")
+	set.DurationVar(&c.Interval, "i", 0, "interval")
+	set.BoolVar(&c.CSV, "c", false, "csv")
+	set.BoolVar(&c.Header, "header", true, "csv header")
+	set.BoolVar(&c.Keep, "k", false, "keep invalid files")
+	set.BoolVar(&c.Zero, "z", false, "discard row with zero missing")
+	set.StringVar(&c.Zone, "tz", "", "timezone")
+	set.BoolVar(&c.Long, "seq64", false, "64 bits sequence")
+	set.StringVar(&c.Logfile, "logfile", "", "log file")
+	return &c
+}
+
+func runBoth(cmd *cli.Command, args []string) error {
+	c := newBothConfig(&cmd.Flag)
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if err := loadConfig(&cmd.Flag, c.Config, "both"); err != nil {
+		return err
+	}
+	zone, err := loadZone(c.Zone)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Flag.NArg() == 0 {
+		cmd.Help()
+	}
+
+	var w io.Writer = os.Stdout
+	if c.Logfile != "" {
+		f, err := openLog(c.Logfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = io.MultiWriter(w, f)
+	}
+
+	paths, err := selectPaths(cmd.Flag.Args(), c.Period, c.Start.Time, c.End.Time, c.Year, c.Days)
+	if err != nil {
+		return err
+	}
+	// files are walked in the same order as check-upi does.
+	rs, gs := countAndCheck(walkFiles(paths, scanOptions{
+		UPI:      c.UPI,
+		Parallel: 1,
+		Buffer:   c.Buffer,
+		Parse:    parseOptions{Long: c.Long},
+	}), c.Interval, c.Keep)
+
+	if len(rs) > 0 {
+		opts := walkOptions{
+			CSV:    c.CSV,
+			Header: c.Header,
+			Now:    time.Now(),
+			Zone:   zone,
+		}
+		reportWalkResults(w, sortCozes(rs, c.Zero), opts)
+	}
+	if len(gs) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	opts := checkOptions{
+		CSV:  c.CSV,
+		Zone: zone,
+	}
+	if c.CSV && c.Header {
+		writeHeader(w, checkColumns(opts))
+	}
+	reportCheckResults(w, gs, opts)
+	return nil
+}
+
+// countAndCheck gives the files counted like walk and the gaps found like
+// check-upi (by UPI, merged and sorted) for the files of queue.
+func countAndCheck(queue <-chan *File, interval time.Duration, keep bool) (map[string]*Coze, []*Gap) {
+	files, gaps := teeFiles(queue)
+
+	var gs []*Gap
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gs = checkFiles(gaps, interval, keep, 0, byUPI)
+	}()
+	rs := countFiles(files, runtime.NumCPU(), countOptions{KeepInvalid: keep})
+	<-done
+
+	gs = dedupeGaps(gs)
+	sortGaps(gs, false)
+	return rs, gs
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountAndCheck(t *testing.T) {
+	// the counters have one digit to be walked in their order.
+	var names []string
+	for upi, seqs := range map[string][]int{
+		"AAA": {1, 2, 3, 4},
+		"BBB": {1, 3, 4, 8, 9},
+		"CCC": {2, 3, 7},
+	} {
+		for _, s := range seqs {
+			names = append(names, fmt.Sprintf("0038_%s_1_%d_20190227_1010%02d_00.dat", upi, s, s))
+		}
+	}
+	dir := t.TempDir()
+	testArchive(t, dir, names...)
+	paths := []string{filepath.Join(dir, "38")}
+	opts := scanOptions{Parallel: 1}
+
+	var walk, check bytes.Buffer
+	reportWalkResults(&walk, sortCozes(countFiles(walkFiles(paths, opts), 1, countOptions{}), false), walkOptions{CSV: true, Now: testEpoch})
+	gs := checkFiles(walkFiles(paths, opts), 0, false, 0, byUPI)
+	gs = dedupeGaps(gs)
+	sortGaps(gs, false)
+	reportCheckResults(&check, gs, checkOptions{CSV: true})
+
+	rs, gs := countAndCheck(walkFiles(paths, opts), 0, false)
+	if len(gs) != 3 {
+		t.Errorf("want 3 gaps, got %d", len(gs))
+	}
+	var both bytes.Buffer
+	reportWalkResults(&both, sortCozes(rs, false), walkOptions{CSV: true, Now: testEpoch})
+	if got, want := both.String(), walk.String(); got != want {
+		t.Errorf("files: want\n%s\ngot\n%s", want, got)
+	}
+	both.Reset()
+	reportCheckResults(&both, gs, checkOptions{CSV: true})
+	if got, want := both.String(), check.String(); got != want {
+		t.Errorf("gaps: want\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// readCadences reads from the file p the time expected between two files of
+// an UPI. Each line contains an UPI (or a pair source/UPI) and a duration (eg
+// 10s) separated by blanks. Empty lines and lines starting with a # are
+// ignored.
+func readCadences(p string) (map[string]time.Duration, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cs := make(map[string]time.Duration)
+	s := bufio.NewScanner(r)
+	for i := 1; s.Scan(); i++ {
+		v := strings.TrimSpace(s.Text())
+		if len(v) == 0 || strings.HasPrefix(v, "#") {
+			continue
+		}
+		ps := strings.Fields(v)
+		if len(ps) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid cadence %q (UPI DURATION)", p, i, v)
+		}
+		d, err := time.ParseDuration(ps[1])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid duration %s", p, i, ps[1])
+		}
+		cs[ps[0]] = d
+	}
+	return cs, s.Err()
+}
+
+// cadenceOf gives the cadence expected for the files of the key k (source/UPI).
+func cadenceOf(k string, cs map[string]time.Duration) (time.Duration, bool) {
+	if d, ok := cs[k]; ok {
+		return d, ok
+	}
+	for e, d := range cs {
+		if isExpected(k, e) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// trackCadence forwards the files of queue and records the files of the UPI
+// that have an expected cadence in cs. The returned map can be safely read
+// once the returned channel is closed.
+func trackCadence(queue <-chan *File, cs map[string]time.Duration, keep bool) (<-chan *File, map[string][]*File) {
+	q := make(chan *File)
+	fs := make(map[string][]*File)
+	go func() {
+		defer close(q)
+		known := make(map[string]bool)
+		for f := range queue {
+			k := f.String()
+			ok, seen := known[k]
+			if !seen {
+				_, ok = cadenceOf(k, cs)
+				known[k] = ok
+			}
+			if ok && (keep || f.Valid()) {
+				fs[k] = append(fs[k], f)
+			}
+			q <- f
+		}
+	}()
+	return q, fs
+}
+
+// slowGaps gives a gap without missing files for each pair of files with
+// consecutive sequence counters acquired more than factor times the cadence
+// expected for their UPI apart: the files are not lost but delivered slower
+// than expected.
+func slowGaps(fs map[string][]*File, cs map[string]time.Duration, factor float64) []*Gap {
+	var gs []*Gap
+	for k, vs := range fs {
+		cadence, _ := cadenceOf(k, cs)
+		limit := time.Duration(float64(cadence) * factor)
+
+		sort.Slice(vs, func(i, j int) bool { return vs[i].Sequence < vs[j].Sequence })
+		for i := 1; i < len(vs); i++ {
+			p, f := vs[i-1], vs[i]
+			if f.Sequence != p.Sequence+1 || f.AcqTime.Sub(p.AcqTime) <= limit {
+				continue
+			}
+			gs = append(gs, &Gap{
+				UPI:    k,
+				Source: gapSource(p, f),
+				Before: p.Sequence,
+				After:  f.Sequence,
+				Starts: p.AcqTime,
+				Ends:   f.AcqTime,
+				slow:   true,
+			})
+		}
+	}
+	return gs
+}
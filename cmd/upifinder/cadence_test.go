@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowGaps(t *testing.T) {
+	// the counters are contiguous but the time between two files widens: i
+	// seconds between the files i and i+1.
+	var (
+		fs  []*File
+		acq = testEpoch
+	)
+	for i := uint64(1); i <= 10; i++ {
+		for _, upi := range []string{"XYZ", "ABC"} {
+			f := testFile(upi, i, false)
+			f.AcqTime = acq
+			fs = append(fs, f)
+		}
+		acq = acq.Add(time.Duration(i) * time.Second)
+	}
+	// not consecutive: a missing file, not a slow one.
+	last := testFile("XYZ", 20, false)
+	last.AcqTime = acq.Add(time.Hour)
+	fs = append(fs, last)
+
+	cs := map[string]time.Duration{"38/XYZ": time.Second}
+	data := []struct {
+		Factor float64
+		Want   []gapBounds
+	}{
+		{Factor: 2, Want: []gapBounds{{3, 4}, {4, 5}, {5, 6}, {6, 7}, {7, 8}, {8, 9}, {9, 10}}},
+		{Factor: 4, Want: []gapBounds{{5, 6}, {6, 7}, {7, 8}, {8, 9}, {9, 10}}},
+		{Factor: 10},
+	}
+	for _, d := range data {
+		queue, beats := trackCadence(feedFiles(fs), cs, false)
+		drainFiles(queue)
+
+		gs := slowGaps(beats, cs, d.Factor)
+		sortGaps(gs, false)
+		compareGaps(t, gs, d.Want)
+		for _, g := range gs {
+			if !g.slow || g.Count() != 0 || g.UPI != "38/XYZ" {
+				t.Errorf("factor %.0f: unexpected gap %+v", d.Factor, *g)
+			}
+		}
+	}
+}
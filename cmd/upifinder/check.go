@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"sort"
 	"strings"
@@ -13,7 +16,7 @@ import (
 )
 
 var checkCommand = &cli.Command{
-	Usage: "check-upi [-b] [-d] [-s] [-e] [-u] [-i] [-c] [-g] [-k] <archive,...>",
+	Usage: "check-upi [-b] [-d] [-s] [-e] [-year] [-doy] [-u] [-i] [-c] [-header] [-jl] [-rename] [-omitempty] [-g] [-leap] [-k] [-reverse] [-group-source] [-reset] [-merge-sources] [-daily] [-timeline] [-cadence] [-factor] [-expect] [-minsize] [-maxsize] [-tz] [-strict] [-logfile] [-from] [-seq64] [-no-recurse] [-drops] [-config] [-ignore-ext] <archive,...>",
 	Alias: []string{"check"},
 	Short: "provide the number of missing files in the archive by UPI",
 	Run:   runCheck,
@@ -31,94 +34,670 @@ of the value given to the command line):
   * [d]       : walk from TODAY - DAYS to TODAY
   * default   : walk recursively on the given path(s)
 
+A path without YYYY sub directories (eg an export directory) is always walked
+recursively whatever the period, with a warning. When its sub directories have
+YYYY sub directories (eg the parent directory of the sources), the period is
+selected in these sub directories instead.
+
 Options:
 
   -b BY      check gaps by upi or by source (default by upi)
   -u UPI     only count files for the given UPI
+  -buffer N  number of files found in advance of their processing (default 1024)
+  -config FILE  read the options not given on the command line from FILE (see
+             configuration file in README)
+  -no-recurse  only find the files directly in the given paths (or in the paths
+             selected for the period) and skip their sub directories
   -s START   only count files created after START
   -e END     only count files created before END
   -d DAYS    only count files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only count files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
   -i TIME    only consider gap with at least TIME duration
   -c         print the results as csv
+  -header    print the names of the columns as first row of the csv (default
+             true, disabled with -header=false)
+  -jl        print the results as json (one object per line)
+             once all the gaps are found, merged and sorted (not streamed)
+  -rename OLD=NEW  rename the field OLD of the json objects to NEW. Can be
+             repeated. NEW can not be the name of another field
+  -omitempty  do not write the fields of the json objects with a zero value
   -a         keep all gaps even when a later playback/replay refill those
   -k         keep invalid files in the count of gaps
-  -g         print the ACQTIME as seconds elapsed since GPS epoch`,
+  -g         print the ACQTIME as seconds elapsed since GPS epoch
+  -leap      apply the leap seconds when converting time to GPS (default no)
+  -reverse   print the most recent gaps first
+  -group-source  print the gaps grouped by the source of their files, then
+             ordered as without this option, with a column for their source
+  -reset N   start a new run when the sequence counter of a file is more than N
+             below the highest one of its UPI and the file has been acquired
+             later (see below)
+  -merge-sources  check the files of an UPI from all sources together and
+             attribute each gap to the sources without files during the gap
+             (see below)
+  -daily     split the gaps crossing midnight into one gap per day (see below)
+  -cadence FILE  report the consecutive files of the UPI listed in FILE with
+             their expected cadence that are acquired more than -factor times
+             the cadence apart (see below)
+  -factor F  factor applied to the cadence given with -cadence (default 2)
+  -timeline N  print the periods of time during which at least N UPI have a
+             gap at the same time instead of the gaps (see below)
+  -expect FILE  report the UPI listed in FILE even when no files are found
+  -minsize N  only count files of at least N bytes
+  -maxsize N  only count files of at most N bytes
+  -tz ZONE   print the times in the timezone ZONE (IANA name, eg Europe/Brussels)
+             instead of UTC
+  -strict    fail with the list of the files that can not be parsed or that
+             are discarded (name, pattern, type, origin or source)
+  -logfile FILE  also write the results to FILE (reopened on SIGHUP)
+  -from FILE  read the files from FILE (the output of files -j) instead of
+             the archive
+  -origin T=LIST  accept the origins given by LIST for the files of type T. LIST
+                  is images, sciences or a list of hexadecimal origins
+  -pattern RE  parse the filenames with the given regular expression instead
+                of splitting them on underscores (see below)
+  -upi-fields F:L  build the UPI with the fields F to L (excluded) of the
+                filename. Negative values count from the end (default 1:-5)
+  -ignore-ext EXT  skip the files with the extension EXT (eg .md5) in addition
+             to the xml files. Can be repeated or given as a comma separated list
+  -drops FILE  write to FILE (csv) the files dropped because their filename can
+             not be parsed or is discarded, with the code of the reason (see
+             README)
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)
+  -delta WHAT   compute the reception time from the source (first field
+                of the filename) or the suffix (last field of the filename)
+                given as a number of minutes (default source)
+
+Runs:
+
+when the sequence counter of an UPI is reset (eg at the start of a new
+acquisition run), the files of the new run would refill the gaps of the
+previous run. With -reset, the files of each run are checked separately and
+the start of a run is reported as a gap with zero missing files and with reset
+as status.
+
+Merged sources:
+
+when an UPI is delivered by several sources, its sequence counters are shared
+between the sources and each source alone seems to have gaps. With
+-merge-sources, the files of an UPI are checked together whatever their source
+(printed as *) and each gap is attributed to the sources of the UPI without
+files acquired during the gap. The sources are printed in the last column.
+
+Daily gaps:
+
+the sequence counters of the missing files of a gap and their acquisition time
+are unknown. With -daily, the missing files are assumed to be evenly spread
+between the two files around the gap (linear interpolation of the sequence
+counter over time) and the gap is split at each midnight (UTC). The sequence
+counters around each part are then computed, not read from existing files.
+
+Cadence:
+
+the file given to -cadence lists an UPI (or a pair source/UPI) and the time
+expected between two of its files (eg 10s) by line. Two files with consecutive
+sequence counters acquired more than -factor times the cadence apart are
+reported as a gap with zero missing files and with slow as status: the files
+are not lost but delivered slower than expected.
+
+Timeline:
+
+with -timeline, the gaps of all UPI are put on one time axis. Each period of
+time during which at least N UPI have a gap at the same time is printed with
+the highest number of UPI with a gap at the same time, the number of UPI with a
+gap during the period and their list. Concurrent gaps of many UPI likely come
+from a problem of the ground segment instead of a problem of one instrument.
+
+Filename pattern:
+
+the regular expression given to -pattern should define the named groups source
+(hexadecimal), upi, sequence and time (YYYYmmddHHMMSS). A date group (YYYYmmdd)
+can be given and then time only contains HHMMSS. The type and delta groups are
+optional and are used to select the accepted origins and to compute the
+reception time.`,
 }
 
-func runCheck(cmd *cli.Command, args []string) error {
-	var start, end When
-	cmd.Flag.Var(&start, "s", "start")
-	cmd.Flag.Var(&end, "e", "end")
-	by := cmd.Flag.String("b", "", "by")
-	upi := cmd.Flag.String("u", "", "upi")
-	period := cmd.Flag.Int("d", 0, "period")
-	interval := cmd.Flag.Duration("i", 0, "interval")
-	csv := cmd.Flag.Bool("c", false, "csv")
-	toGPS := cmd.Flag.Bool("g", false, "convert time to GPS")
-	keep := cmd.Flag.Bool("k", false, "keep invalid files")
+// checkConfig holds the options of check-upi given on the command line or in
+// the configuration file (see -config).
+type checkConfig struct {
+	Start, End   When
+	By           string
+	UPI          string
+	Buffer       int
+	Config       string
+	NoRecurse    bool
+	Period       int
+	Year         int
+	Days         DayList
+	Interval     time.Duration
+	CSV          bool
+	Header       bool
+	JSON         bool
+	Profile      jsonProfile
+	GPS          bool
+	Leap         bool
+	Keep         bool
+	Reverse      bool
+	GroupSource  bool
+	Daily        bool
+	Timeline     int
+	Cadence      string
+	Factor       float64
+	Reset        uint
+	MergeSources bool
+	Delta        string
+	Parse        parseOptions
+	Ignored      ExtList
+	Drops        string
+	Pattern      string
+	Zone         string
+	Expect       string
+	MinSize      int64
+	MaxSize      int64
+	Logfile      string
+	Strict       bool
+	From         string
+}
+
+// newCheckConfig defines the flags of check-upi in set.
+func newCheckConfig(set *flag.FlagSet) *checkConfig {
+	c := checkConfig{
+		Parse:   newParseOptions(),
+		Profile: newJSONProfile(),
+	}
+	set.Var(&c.Start, "s", "start")
+	set.Var(&c.End, "e", "end")
+	set.StringVar(&c.By, "b", "", "by")
+	set.StringVar(&c.UPI, "u", "", "upi")
+	set.IntVar(&c.Buffer, "buffer", DefaultBuffer, "buffer")
+	set.StringVar(&c.Config, "config", "", "config file")
+	set.BoolVar(&c.NoRecurse, "no-recurse", false, "no recursion")
+	set.IntVar(&c.Period, "d", 0, "period")
+	set.IntVar(&c.Year, "year", 0, "year")
+	set.Var(&c.Days, "doy", "days of year")
+	set.DurationVar(&c.Interval, "i", 0, "interval")
+	set.BoolVar(&c.CSV, "c", false, "csv")
+	set.BoolVar(&c.Header, "header", true, "csv header")
+	set.BoolVar(&c.JSON, "jl", false, "json lines")
+	set.Var(c.Profile.Names, "rename", "rename json fields")
+	set.BoolVar(&c.Profile.OmitEmpty, "omitempty", false, "omit empty json fields")
+	set.BoolVar(&c.GPS, "g", false, "convert time to GPS")
+	set.BoolVar(&c.Leap, "leap", false, "leap seconds")
+	set.BoolVar(&c.Keep, "k", false, "keep invalid files")
+	set.BoolVar(&c.Reverse, "reverse", false, "reverse")
+	set.BoolVar(&c.GroupSource, "group-source", false, "group by source")
+	set.BoolVar(&c.Daily, "daily", false, "daily gaps")
+	set.IntVar(&c.Timeline, "timeline", 0, "concurrent gaps")
+	set.StringVar(&c.Cadence, "cadence", "", "expected cadences")
+	set.Float64Var(&c.Factor, "factor", 2, "cadence factor")
+	set.UintVar(&c.Reset, "reset", 0, "sequence reset")
+	set.BoolVar(&c.MergeSources, "merge-sources", false, "merge sources")
+	set.StringVar(&c.Delta, "delta", "source", "delta")
+	set.Var(&c.Parse.Fields, "upi-fields", "upi fields")
+	set.Var(&c.Ignored, "ignore-ext", "ignored extensions")
+	set.StringVar(&c.Drops, "drops", "", "dropped files")
+	set.BoolVar(&c.Parse.Long, "seq64", false, "64 bits sequence")
+	set.StringVar(&c.Pattern, "pattern", "", "filename pattern")
+	set.StringVar(&c.Zone, "tz", "", "timezone")
+	set.Var(c.Parse.Origins, "origin", "origins by type")
+	set.StringVar(&c.Expect, "expect", "", "expected upi")
+	set.Int64Var(&c.MinSize, "minsize", 0, "minimum size")
+	set.Int64Var(&c.MaxSize, "maxsize", 0, "maximum size")
+	set.StringVar(&c.Logfile, "logfile", "", "log file")
+	set.BoolVar(&c.Strict, "strict", false, "strict")
+	set.StringVar(&c.From, "from", "", "files list")
+	return &c
+}
 
+func runCheck(cmd *cli.Command, args []string) error {
+	c := newCheckConfig(&cmd.Flag)
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	if err := loadConfig(&cmd.Flag, c.Config, "check"); err != nil {
+		return err
+	}
+	if c.CSV && c.JSON {
+		return fmt.Errorf("csv and json can not be set together")
+	}
+	var err error
+	if c.Parse.Delta, err = parseDelta(c.Delta); err != nil {
+		return err
+	}
+	if c.Parse.Pattern, err = compilePattern(c.Pattern); err != nil {
+		return err
+	}
+	zone, err := loadZone(c.Zone)
+	if err != nil {
+		return err
+	}
 
-	if cmd.Flag.NArg() == 0 {
+	if cmd.Flag.NArg() == 0 && c.From == "" {
 		cmd.Help()
 	}
 
-	paths, err := listPaths(cmd.Flag.Args(), *period, start.Time, end.Time)
-	if err != nil {
-		return err
+	var w io.Writer = os.Stdout
+	if c.Logfile != "" {
+		f, err := openLog(c.Logfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = io.MultiWriter(w, f)
 	}
+	var drops *dropWriter
+	if c.Drops != "" {
+		f, err := os.Create(c.Drops)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		drops = newDropWriter(f)
+	}
+	var rejected *rejectList
+	if c.Strict {
+		rejected = new(rejectList)
+	}
+
 	var byf ByFunc
-	switch strings.ToLower(*by) {
+	switch strings.ToLower(c.By) {
 	case "upi", "":
 		byf = byUPI
 	case "source":
 		byf = bySource
 	default:
-		return fmt.Errorf("unsupported %s", *by)
+		return fmt.Errorf("unsupported %s", c.By)
+	}
+	if c.MergeSources && strings.ToLower(c.By) == "source" {
+		return fmt.Errorf("merge-sources and b source can not be set together")
+	}
+	var upis []string
+	if c.Expect != "" {
+		if upis, err = readExpected(c.Expect); err != nil {
+			return err
+		}
+	}
+
+	var queue <-chan *File
+	if c.From != "" {
+		fs, err := loadFiles(c.From, c.UPI)
+		if err != nil {
+			return err
+		}
+		queue = feedFiles(fs)
+	} else {
+		paths, err := selectPaths(cmd.Flag.Args(), c.Period, c.Start.Time, c.End.Time, c.Year, c.Days)
+		if err != nil {
+			return err
+		}
+		queue = walkFiles(paths, scanOptions{
+			UPI:       c.UPI,
+			Parallel:  1,
+			Buffer:    c.Buffer,
+			NoRecurse: c.NoRecurse,
+			Ignored:   c.Ignored,
+			Parse:     c.Parse,
+			Rejected:  rejected,
+			Drops:     drops,
+		})
+	}
+	if c.MinSize > 0 || c.MaxSize > 0 {
+		queue = filterFiles(queue, bySize(c.MinSize, c.MaxSize))
+	}
+	var cadences map[string]time.Duration
+	if c.Cadence != "" {
+		if cadences, err = readCadences(c.Cadence); err != nil {
+			return err
+		}
+	}
+
+	var times sourceTimes
+	if c.MergeSources {
+		queue, times = mergeSources(queue)
+	}
+	var keys map[string]struct{}
+	if len(upis) > 0 {
+		queue, keys = trackKeys(queue, byf)
+	}
+	var beats map[string][]*File
+	if len(cadences) > 0 {
+		queue, beats = trackCadence(queue, cadences, c.Keep)
+	}
+	rs := checkFiles(queue, c.Interval, c.Keep, c.Reset, byf)
+	if len(cadences) > 0 {
+		rs = append(rs, slowGaps(beats, cadences, c.Factor)...)
+	}
+	if err := rejected.check(os.Stderr); err != nil {
+		return err
+	}
+	if len(upis) > 0 {
+		rs = expectGaps(rs, keys, upis)
+	}
+	if len(rs) == 0 {
+		return nil
+	}
+	if c.MergeSources {
+		attributeGaps(rs, times)
+	}
+	rs = dedupeGaps(rs)
+	if c.Timeline > 0 {
+		vs := timelineGaps(rs, c.Timeline)
+		if c.JSON {
+			return reportTimelineJSON(w, vs, c.Profile)
+		}
+		if c.CSV && c.Header {
+			writeHeader(w, []string{"acq_start", "acq_end", "duration", "max", "count", "upis"})
+		}
+		reportTimelineResults(w, vs, c.CSV, zone)
+		return nil
+	}
+	if c.Daily {
+		rs = splitDaily(rs)
+	}
+	sortGaps(rs, c.Reverse)
+	if c.GroupSource {
+		groupSources(rs)
+	}
+	opts := checkOptions{
+		CSV:     c.CSV,
+		GPS:     c.GPS,
+		Leap:    c.Leap,
+		Status:  len(upis) > 0 || c.Reset > 0 || len(cadences) > 0,
+		Source:  c.GroupSource,
+		Sources: c.MergeSources,
+		Zone:    zone,
+		Profile: c.Profile,
+	}
+	if c.JSON {
+		return reportCheckJSON(w, rs, opts)
 	}
-	if rs := checkFiles(walkFiles(paths, *upi, 1), *interval, *keep, byf); len(rs) > 0 {
-		reportCheckResults(rs, *csv, *toGPS)
+	if c.CSV && c.Header {
+		writeHeader(w, checkColumns(opts))
 	}
+	reportCheckResults(w, rs, opts)
 	return nil
 }
 
-func reportCheckResults(gs []*Gap, csv, gps bool) {
-	line := Line(csv)
+// checkOptions controls the columns printed by reportCheckResults and the
+// fields written by reportCheckJSON.
+type checkOptions struct {
+	CSV bool
+	// GPS is set to print the times as seconds elapsed since the GPS epoch,
+	// corrected by the leap seconds when Leap is set.
+	GPS  bool
+	Leap bool
+	// Status is set to print the status of the gaps (see -expect, -reset and
+	// -cadence).
+	Status bool
+	// Source is set to print the source of the gaps (see -group-source).
+	Source bool
+	// Sources is set to print the sources to which the gaps are attributed
+	// (see -merge-sources).
+	Sources bool
+	// Zone is the timezone of the times printed (see -tz).
+	Zone *time.Location
+	// Profile changes the fields of the json objects.
+	Profile jsonProfile
+}
+
+type gapRecord struct {
+	*Gap
+	Missing  uint64  `json:"missing"`
+	Duration float64 `json:"duration"`
+	GPSStart uint64  `json:"gpsstart,omitempty"`
+	GPSEnd   uint64  `json:"gpsend,omitempty"`
+	Absent   bool    `json:"absent,omitempty"`
+	Reset    bool    `json:"reset,omitempty"`
+	Slow     bool    `json:"slow,omitempty"`
+}
+
+// reportCheckJSON writes each gap of gs as a json object on its own line.
+// The gaps are only known once all the files are checked, so nothing is
+// written before checkFiles returns.
+func reportCheckJSON(w io.Writer, gs []*Gap, opts checkOptions) error {
+	e := json.NewEncoder(w)
+	for _, g := range gs {
+		r := gapRecord{
+			Gap:      g,
+			Missing:  g.Count(),
+			Duration: g.Duration().Seconds(),
+			Absent:   g.absent,
+			Reset:    g.reset,
+			Slow:     g.slow,
+		}
+		if opts.GPS && !g.absent {
+			r.GPSStart, r.GPSEnd = timeToGPS(g.Starts, opts.Leap), timeToGPS(g.Ends, opts.Leap)
+		}
+		if err := e.Encode(withProfile(r, opts.Profile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkColumns gives the names of the columns printed by reportCheckResults.
+func checkColumns(opts checkOptions) []string {
+	cols := []string{"upi", "acq_start", "acq_end", "duration", "duration_text", "seq_start", "seq_end", "missing"}
+	if opts.Status {
+		cols = append(cols, "status")
+	}
+	if opts.Source {
+		cols = append(cols, "source")
+	}
+	if opts.Sources {
+		cols = append(cols, "sources")
+	}
+	return cols
+}
+
+func reportCheckResults(w io.Writer, gs []*Gap, opts checkOptions) {
+	line := Line(opts.CSV)
 	for i := 0; i < len(gs); i++ {
 		g := gs[i]
 
 		line.AppendString(Transform(g.UPI), 24, linewriter.AlignLeft)
-		if gps {
-			line.AppendUint(timeToGPS(g.Starts), 10, linewriter.AlignRight)
-			line.AppendUint(timeToGPS(g.Ends), 10, linewriter.AlignRight)
+		if opts.GPS {
+			line.AppendUint(timeToGPS(g.Starts, opts.Leap), 10, linewriter.AlignRight)
+			line.AppendUint(timeToGPS(g.Ends, opts.Leap), 10, linewriter.AlignRight)
 		} else {
-			line.AppendTime(g.Starts, time.RFC3339, linewriter.AlignRight)
-			line.AppendTime(g.Ends, time.RFC3339, linewriter.AlignRight)
+			line.AppendTime(Local(g.Starts, opts.Zone), time.RFC3339, linewriter.AlignRight)
+			line.AppendTime(Local(g.Ends, opts.Zone), time.RFC3339, linewriter.AlignRight)
 		}
-		if elapsed := g.Duration(); csv {
+		if elapsed := g.Duration(); opts.CSV {
 			line.AppendUint(uint64(elapsed.Seconds()), 10, linewriter.AlignRight)
+			line.AppendString(elapsed.String(), 10, linewriter.AlignRight)
 		} else {
 			line.AppendDuration(elapsed, 10, linewriter.AlignRight)
 		}
-		line.AppendUint(uint64(g.Before), 10, linewriter.AlignRight)
-		line.AppendUint(uint64(g.After), 10, linewriter.AlignRight)
-		line.AppendUint(uint64(g.Count()), 10, linewriter.AlignRight)
+		line.AppendUint(g.Before, 10, linewriter.AlignRight)
+		line.AppendUint(g.After, 10, linewriter.AlignRight)
+		line.AppendUint(g.Count(), 10, linewriter.AlignRight)
+		if opts.Status {
+			appendGapStatus(line, g)
+		}
+		if opts.Source {
+			line.AppendString(g.Source, 8, linewriter.AlignLeft)
+		}
+		if opts.Sources {
+			line.AppendString(strings.Join(g.Sources, ","), 8, linewriter.AlignLeft)
+		}
 
-		io.Copy(os.Stdout, line)
+		io.Copy(w, line)
 	}
 }
 
-func checkFiles(files <-chan *File, interval time.Duration, keep bool, by ByFunc) []*Gap {
+// sortGaps orders gs by UPI and then from the oldest to the most recent gap
+// like the UPI are ordered by walk. If reverse is set, gs is ordered from the
+// most recent to the oldest gap whatever their UPI.
+func sortGaps(gs []*Gap, reverse bool) {
+	sort.Slice(gs, func(i, j int) bool {
+		return lessGap(gs[i], gs[j], reverse)
+	})
+}
+
+// groupSources orders gs by the source of their files and keeps the order of
+// the gaps of the same source. Sources are ordered by their hexadecimal value.
+func groupSources(gs []*Gap) {
+	sort.SliceStable(gs, func(i, j int) bool {
+		a, b := gs[i].Source, gs[j].Source
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
+
+// dedupeGaps merges the gaps of the same UPI that overlap (they share at least
+// one missing file) or that have the same bounds. Adjacent gaps (the last file
+// missing of one is followed by the first file missing of the other) are kept
+// apart.
+func dedupeGaps(gs []*Gap) []*Gap {
+	if len(gs) <= 1 {
+		return gs
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		if gs[i].UPI != gs[j].UPI {
+			return gs[i].UPI < gs[j].UPI
+		}
+		if gs[i].run != gs[j].run {
+			return gs[i].run < gs[j].run
+		}
+		return gs[i].Before < gs[j].Before
+	})
+	var (
+		rs   = gs[:1]
+		prev = gs[0]
+	)
+	for _, g := range gs[1:] {
+		same := g.Before == prev.Before && g.After == prev.After
+		if g.UPI == prev.UPI && g.run == prev.run && (same || prev.Overlaps(g)) {
+			if g.After > prev.After {
+				prev.After, prev.Ends = g.After, g.Ends
+			}
+			continue
+		}
+		rs = append(rs, g)
+		prev = g
+	}
+	return rs
+}
+
+// splitDaily splits the gaps of gs crossing midnight into one gap per day. The
+// missing files are assumed to be evenly spread over the duration of a gap.
+func splitDaily(gs []*Gap) []*Gap {
+	var rs []*Gap
+	for _, g := range gs {
+		rs = append(rs, splitGap(g)...)
+	}
+	return rs
+}
+
+func splitGap(g *Gap) []*Gap {
+	if g.Count() == 0 || !g.Ends.After(g.Starts) {
+		return []*Gap{g}
+	}
+	var (
+		rs      []*Gap
+		prev    = *g
+		y, m, d = g.Starts.Date()
+		span    = float64(g.After - g.Before)
+	)
+	for {
+		mid := time.Date(y, m, d+1, 0, 0, 0, 0, g.Starts.Location())
+		if !mid.Before(g.Ends) {
+			break
+		}
+		// missing files with a sequence counter lower than cut are acquired
+		// before midnight.
+		frac := float64(mid.Sub(g.Starts)) / float64(g.Duration())
+		cut := uint64(math.Ceil(float64(g.Before) + frac*span))
+		if cut <= prev.Before {
+			cut = prev.Before + 1
+		}
+		if cut > g.After {
+			cut = g.After
+		}
+		part := prev
+		part.After, part.Ends = cut, mid
+		if part.Count() > 0 {
+			rs = append(rs, &part)
+		}
+		prev.Before, prev.Starts = cut-1, mid
+		y, m, d = mid.Date()
+	}
+	if prev.Count() > 0 {
+		rs = append(rs, &prev)
+	}
+	return rs
+}
+
+func lessGap(a, b *Gap, reverse bool) bool {
+	if !reverse && a.UPI != b.UPI {
+		return a.UPI < b.UPI
+	}
+	if !a.Starts.Equal(b.Starts) {
+		if reverse {
+			return a.Starts.After(b.Starts)
+		}
+		return a.Starts.Before(b.Starts)
+	}
+	if a.UPI != b.UPI {
+		return a.UPI < b.UPI
+	}
+	return a.Before < b.Before
+}
+
+// isReset reports whether f is the first file of a new run after p: when n is
+// set, f is acquired later than p with a sequence counter more than n below the
+// one of p (the counter has been reset).
+func isReset(p, f *File, n uint) bool {
+	if n == 0 || f.Sequence >= p.Sequence || !f.AcqTime.After(p.AcqTime) {
+		return false
+	}
+	return uint(p.Sequence-f.Sequence) > n
+}
+
+func runKey(n string, runs map[string]int) string {
+	if r := runs[n]; r > 0 {
+		return fmt.Sprintf("%s#%d", n, r)
+	}
+	return n
+}
+
+// checkFiles gives the gaps between the files of files by key (see by). The
+// gaps shorter than interval are skipped. When reset is set, the files of a new
+// run (see isReset) are checked separately from the files of the previous
+// runs.
+func checkFiles(files <-chan *File, interval time.Duration, keep bool, reset uint, by ByFunc) []*Gap {
 	rs := make(map[string][]*Gap)
 	cs := make(map[string]*File)
 	qs := make(map[string][]*Range)
+
+	var (
+		runs   = make(map[string]int)
+		resets []*Gap
+	)
 	for f := range files {
 		if !f.Valid() && !keep {
 			continue
 		}
-		n := by(f)
+		// the files of a new run (see isReset) are checked separately from the
+		// files of the previous runs.
+		n := runKey(by(f), runs)
+		if p, ok := cs[n]; ok && isReset(p, f, reset) {
+			resets = append(resets, &Gap{
+				UPI:    p.String(),
+				Source: gapSource(p, f),
+				Before: p.Sequence,
+				After:  f.Sequence,
+				Starts: p.AcqTime,
+				Ends:   f.AcqTime,
+				reset:  true,
+			})
+			runs[by(f)]++
+			n = runKey(by(f), runs)
+		}
 		if s, ok := inRanges(qs[n], f.Sequence); !ok {
 			qs[n] = s
 		} else {
@@ -153,22 +732,16 @@ func checkFiles(files <-chan *File, interval time.Duration, keep bool, by ByFunc
 			if !skip {
 				g := f.Compare(p)
 				if (g != nil && g.Count() > 0) && (interval == 0 || g.Duration() >= interval) {
+					g.run = runs[by(f)]
 					rs[n] = append(rs[n], g)
 				}
 			}
 		}
 		cs[n] = f
 	}
-	var gs []*Gap
+	gs := resets
 	for _, vs := range rs {
 		gs = append(gs, vs...)
 	}
 	return gs
 }
-
-func timeToGPS(t time.Time) uint64 {
-	left := t.Sub(UNIX).Seconds()
-	right := GPS.Sub(UNIX).Seconds()
-
-	return uint64(left - right)
-}
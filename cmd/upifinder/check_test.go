@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testGaps gives the gaps found by checkFiles (by UPI) for the files of the
+// UPI upi with the given sequence counters in the order they are found.
+func testGaps(upi string, seqs ...int) []*Gap {
+	var fs []*File
+	for _, s := range seqs {
+		if s < 0 {
+			fs = append(fs, testFile(upi, uint64(-s), true))
+		} else {
+			fs = append(fs, testFile(upi, uint64(s), false))
+		}
+	}
+	gs := checkFiles(feedFiles(fs), 0, false, 0, byUPI)
+	sortGaps(gs, false)
+	return gs
+}
+
+type gapBounds struct {
+	Before uint64
+	After  uint64
+}
+
+func compareGaps(t *testing.T, gs []*Gap, want []gapBounds) {
+	t.Helper()
+	if len(gs) != len(want) {
+		for _, g := range gs {
+			t.Logf("gap: %d-%d", g.Before, g.After)
+		}
+		t.Fatalf("want %d gaps, got %d", len(want), len(gs))
+	}
+	for i, g := range gs {
+		if g.Before != want[i].Before || g.After != want[i].After {
+			t.Errorf("gap %d: want %d-%d, got %d-%d", i, want[i].Before, want[i].After, g.Before, g.After)
+		}
+	}
+}
+
+func TestSortGaps(t *testing.T) {
+	var gs []*Gap
+	for upi, seqs := range map[string][]int{
+		"AAA": {1, 4, 9},
+		"BBB": {2, 6},
+		"CCC": {1, 3},
+	} {
+		gs = append(gs, testGaps(upi, seqs...)...)
+	}
+	data := []struct {
+		Reverse bool
+		UPI     []string
+		Want    []gapBounds
+	}{
+		{
+			// by UPI then from the oldest gap.
+			UPI:  []string{"38/AAA", "38/AAA", "38/BBB", "38/CCC"},
+			Want: []gapBounds{{1, 4}, {4, 9}, {2, 6}, {1, 3}},
+		},
+		{
+			// from the newest gap whatever the UPI, by UPI at the same time.
+			Reverse: true,
+			UPI:     []string{"38/AAA", "38/BBB", "38/AAA", "38/CCC"},
+			Want:    []gapBounds{{4, 9}, {2, 6}, {1, 4}, {1, 3}},
+		},
+	}
+	for _, d := range data {
+		sortGaps(gs, d.Reverse)
+		compareGaps(t, gs, d.Want)
+		for i, g := range gs {
+			if g.UPI != d.UPI[i] {
+				t.Errorf("reverse=%t: gap %d: want %s, got %s", d.Reverse, i, d.UPI[i], g.UPI)
+			}
+		}
+	}
+}
+
+func TestDedupeGaps(t *testing.T) {
+	data := []struct {
+		Sets [][]int
+		Want []gapBounds
+	}{
+		{
+			// overlapping gaps of two path sets: 3-5 and 2-3 then 5-8.
+			Sets: [][]int{{1, 2, 6, 7}, {1, 4, 9}},
+			Want: []gapBounds{{1, 9}},
+		},
+		{
+			// the same gap found in two path sets.
+			Sets: [][]int{{1, 2, 5}, {2, 5, 6}},
+			Want: []gapBounds{{2, 5}},
+		},
+		{
+			// adjacent gaps: 3-4 then 5-6.
+			Sets: [][]int{{2, 5}, {4, 7}},
+			Want: []gapBounds{{2, 5}, {4, 7}},
+		},
+		{
+			// gaps sharing a file found: 3-4 then 6-7.
+			Sets: [][]int{{2, 5}, {5, 8}},
+			Want: []gapBounds{{2, 5}, {5, 8}},
+		},
+	}
+	for i, d := range data {
+		var gs []*Gap
+		for _, s := range d.Sets {
+			gs = append(gs, testGaps("XYZ", s...)...)
+		}
+		gs = dedupeGaps(gs)
+		sortGaps(gs, false)
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			compareGaps(t, gs, d.Want)
+		})
+	}
+}
+
+func TestReportCheckJSON(t *testing.T) {
+	gs := testGaps("XYZ", 1, 3, 4, 8)
+
+	var buf bytes.Buffer
+	if err := reportCheckJSON(&buf, gs, checkOptions{GPS: true}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(rows) != len(gs) {
+		t.Fatalf("want %d lines, got %d", len(gs), len(rows))
+	}
+	for i, r := range rows {
+		var g struct {
+			UPI      string `json:"upi"`
+			Last     uint64 `json:"last"`
+			First    uint64 `json:"first"`
+			Missing  uint64 `json:"missing"`
+			GPSStart uint64 `json:"gpsstart"`
+		}
+		if err := json.Unmarshal([]byte(r), &g); err != nil {
+			t.Errorf("line %d: invalid json: %s", i+1, err)
+			continue
+		}
+		if g.UPI != gs[i].UPI || g.Last != gs[i].Before || g.First != gs[i].After || g.Missing != gs[i].Count() {
+			t.Errorf("line %d: unexpected gap %+v", i+1, g)
+		}
+		if g.GPSStart != timeToGPS(gs[i].Starts, false) {
+			t.Errorf("line %d: want gpsstart %d, got %d", i+1, timeToGPS(gs[i].Starts, false), g.GPSStart)
+		}
+	}
+}
+
+func TestCheckFilesReset(t *testing.T) {
+	var fs []*File
+	for i := 1; i <= 100; i++ {
+		fs = append(fs, testFile("XYZ", uint64(i), false))
+	}
+	// a new run starts after the first one: its counters start again from 1
+	// while the time moves forward.
+	for i, s := range []uint64{1, 2, 5, 6} {
+		f := testFile("XYZ", s, false)
+		f.AcqTime = testEpoch.Add(time.Duration(200+i) * time.Second)
+		fs = append(fs, f)
+	}
+
+	if gs := checkFiles(feedFiles(fs), 0, false, 0, byUPI); len(gs) != 0 {
+		t.Errorf("without reset: want no gap, got %d", len(gs))
+	}
+
+	gs := checkFiles(feedFiles(fs), 0, false, 10, byUPI)
+	sortGaps(gs, false)
+	compareGaps(t, gs, []gapBounds{{100, 1}, {2, 5}})
+	if g := gs[0]; !g.reset || g.Count() != 0 {
+		t.Errorf("want run boundary with no missing file, got %+v", *g)
+	}
+	if g := gs[1]; g.reset || g.run != 1 {
+		t.Errorf("want gap of the second run, got %+v", *g)
+	}
+}
+
+func TestGroupSources(t *testing.T) {
+	var fs []*File
+	for _, s := range []struct {
+		Source string
+		UPI    string
+		Seqs   []uint64
+	}{
+		{Source: "39", UPI: "AAA", Seqs: []uint64{1, 4, 5}},
+		{Source: "38", UPI: "BBB", Seqs: []uint64{1, 2, 6}},
+		{Source: "38", UPI: "AAA", Seqs: []uint64{1, 3}},
+		{Source: "4a", UPI: "AAA", Seqs: []uint64{2, 8}},
+	} {
+		for _, q := range s.Seqs {
+			f := testFile(s.UPI, q, false)
+			f.Source = s.Source
+			fs = append(fs, f)
+		}
+	}
+	gs := checkFiles(feedFiles(fs), 0, false, 0, byUPI)
+	sortGaps(gs, false)
+	groupSources(gs)
+
+	want := []string{"38", "38", "39", "4a"}
+	if len(gs) != len(want) {
+		t.Fatalf("want %d gaps, got %d", len(want), len(gs))
+	}
+	for i, g := range gs {
+		if g.Source != want[i] {
+			t.Errorf("gap %d (%s): want source %s, got %s", i, g.UPI, want[i], g.Source)
+		}
+		if !strings.HasPrefix(g.UPI, g.Source+"/") {
+			t.Errorf("gap %d: source %s not the source of %s", i, g.Source, g.UPI)
+		}
+	}
+
+	var (
+		buf  bytes.Buffer
+		opts = checkOptions{CSV: true, Source: true}
+	)
+	reportCheckResults(&buf, gs, opts)
+	rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	cols := checkColumns(opts)
+	if cols[len(cols)-1] != "source" {
+		t.Errorf("want source as last column, got %s", cols[len(cols)-1])
+	}
+	for i, r := range rows {
+		vs := strings.Split(r, ",")
+		if len(vs) != len(cols) || strings.TrimSpace(vs[len(vs)-1]) != want[i] {
+			t.Errorf("row %d: want source %s, got %q", i, want[i], r)
+		}
+	}
+
+	p, f := testFile("AAA", 1, false), testFile("AAA", 3, false)
+	f.Source = "39"
+	if s := gapSource(p, f); s != "38+39" {
+		t.Errorf("want mixed source 38+39, got %s", s)
+	}
+}
+
+func TestCheckDeterministic(t *testing.T) {
+	// the gaps of the UPI share the same times to be ordered by their UPI. The
+	// counters have two digits to be walked in their order.
+	var names []string
+	for _, upi := range []string{"CCC", "AAA", "BBB", "DDD"} {
+		for _, s := range []int{1, 2, 5, 6, 9, 12} {
+			names = append(names, fmt.Sprintf("0038_%s_1_%02d_20190227_1010%02d_00.dat", upi, s, s))
+		}
+	}
+	dir := t.TempDir()
+	testArchive(t, dir, names...)
+	paths := []string{filepath.Join(dir, "38")}
+
+	run := func() string {
+		gs := checkFiles(walkFiles(paths, scanOptions{Parallel: 4}), 0, false, 0, byUPI)
+		gs = dedupeGaps(gs)
+		sortGaps(gs, false)
+		var buf bytes.Buffer
+		reportCheckResults(&buf, gs, checkOptions{CSV: true})
+		return buf.String()
+	}
+	want := run()
+	if n := strings.Count(want, "\n"); n != 12 {
+		t.Fatalf("want 12 gaps, got %d", n)
+	}
+	for i := 0; i < 10; i++ {
+		if got := run(); got != want {
+			t.Fatalf("run %d: output differs: want\n%s\ngot\n%s", i+2, want, got)
+		}
+	}
+}
+
+func TestSplitDaily(t *testing.T) {
+	day := time.Date(2019, 2, 27, 0, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time {
+		return day.Add(time.Duration(h) * time.Hour)
+	}
+	data := []struct {
+		Gap    Gap
+		Want   []gapBounds
+		Starts []time.Time
+	}{
+		{
+			// in one day: not split.
+			Gap:    Gap{Before: 1, After: 10, Starts: at(10), Ends: at(12)},
+			Want:   []gapBounds{{1, 10}},
+			Starts: []time.Time{at(10)},
+		},
+		{
+			// half of the missing files before midnight.
+			Gap:    Gap{Before: 0, After: 101, Starts: at(23), Ends: at(25)},
+			Want:   []gapBounds{{0, 51}, {50, 101}},
+			Starts: []time.Time{at(23), at(24)},
+		},
+		{
+			// over three days: a quarter, a half and a quarter of the missing
+			// files.
+			Gap:    Gap{Before: 10, After: 59, Starts: at(12), Ends: at(60)},
+			Want:   []gapBounds{{10, 23}, {22, 47}, {46, 59}},
+			Starts: []time.Time{at(12), at(24), at(48)},
+		},
+		{
+			// the only missing file is counted after midnight.
+			Gap:    Gap{Before: 5, After: 7, Starts: at(23), Ends: at(25)},
+			Want:   []gapBounds{{5, 7}},
+			Starts: []time.Time{at(24)},
+		},
+		{
+			// no missing file (eg a run boundary): not split.
+			Gap:    Gap{Before: 5, After: 6, Starts: at(23), Ends: at(25)},
+			Want:   []gapBounds{{5, 6}},
+			Starts: []time.Time{at(23)},
+		},
+	}
+	for i, d := range data {
+		g := d.Gap
+		gs := splitDaily([]*Gap{&g})
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			compareGaps(t, gs, d.Want)
+			var total uint64
+			for j, g := range gs {
+				total += g.Count()
+				if !g.Starts.Equal(d.Starts[j]) {
+					t.Errorf("gap %d: want start %s, got %s", j, d.Starts[j], g.Starts)
+				}
+				if j < len(gs)-1 && !g.Ends.Equal(gs[j+1].Starts) {
+					t.Errorf("gap %d: ends at %s, next starts at %s", j, g.Ends, gs[j+1].Starts)
+				}
+			}
+			if total != d.Gap.Count() {
+				t.Errorf("want %d missing files, got %d", d.Gap.Count(), total)
+			}
+		})
+	}
+}
+
+func TestReportCheckDuration(t *testing.T) {
+	gs := testGaps("XYZ", 1, 3)
+	gs = append(gs, &Gap{
+		UPI:    "38/XYZ",
+		Before: 3,
+		After:  10,
+		Starts: testEpoch,
+		Ends:   testEpoch.Add(time.Hour + 2*time.Minute + 3*time.Second),
+	})
+	want := []struct {
+		Seconds string
+		Text    string
+	}{
+		{Seconds: "2", Text: "2s"},
+		{Seconds: "3723", Text: "1h2m3s"},
+	}
+
+	opts := checkOptions{CSV: true}
+	cols := checkColumns(opts)
+	if cols[3] != "duration" || cols[4] != "duration_text" {
+		t.Fatalf("unexpected columns %v", cols)
+	}
+	var buf bytes.Buffer
+	reportCheckResults(&buf, gs, opts)
+	rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(rows) != len(want) {
+		t.Fatalf("want %d rows, got %d", len(want), len(rows))
+	}
+	for i, r := range rows {
+		vs := strings.Split(r, ",")
+		if len(vs) != len(cols) {
+			t.Errorf("row %d: want %d columns, got %d (%s)", i, len(cols), len(vs), r)
+			continue
+		}
+		if s := strings.TrimSpace(vs[3]); s != want[i].Seconds {
+			t.Errorf("row %d: want duration %s, got %s", i, want[i].Seconds, s)
+		}
+		if s := strings.TrimSpace(vs[4]); s != want[i].Text {
+			t.Errorf("row %d: want duration text %s, got %s", i, want[i].Text, s)
+		}
+	}
+}
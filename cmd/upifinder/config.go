@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/midbel/toml"
+)
+
+// configCommands gives the functions defining the flags of the commands
+// accepting -config by the name of their table in the configuration file.
+var configCommands = map[string]func(*flag.FlagSet){
+	"walk":  func(set *flag.FlagSet) { newWalkConfig(set) },
+	"check": func(set *flag.FlagSet) { newCheckConfig(set) },
+	"both":  func(set *flag.FlagSet) { newBothConfig(set) },
+	"files": func(set *flag.FlagSet) { newFilesConfig(set) },
+}
+
+// loadConfig sets the flags of set that are not given on the command line with
+// the values found in the TOML configuration file p (see README).
+//
+// The keys are the names of the flags. A string is given as on the command
+// line, booleans and numbers can be written without quotes and an array sets a
+// flag that can be repeated once for each of its values.
+//
+// The keys without table are used by all the commands (and ignored when cmd
+// has no such flag) and the keys of the table named cmd are used only by cmd.
+// A key without table must be a flag of one of the commands accepting -config
+// and a key of a table must be a flag of the command of the table.
+func loadConfig(set *flag.FlagSet, p, cmd string) error {
+	if p == "" {
+		return nil
+	}
+	values := make(map[string]interface{})
+	if err := toml.DecodeFile(p, &values); err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	given := make(map[string]bool)
+	set.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	// the table of cmd is applied first so that it wins.
+	for _, key := range sortedKeys(values) {
+		t, ok := values[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := configCommands[key]; !ok {
+			return fmt.Errorf("%s: unknown table %s", p, key)
+		}
+		if err := setConfig(set, p, key, cmd, t, given); err != nil {
+			return err
+		}
+	}
+	return setConfig(set, p, "", cmd, values, given)
+}
+
+// setConfig sets the flags of set with the values of the table of the
+// configuration file p (the keys without table when table is empty). The keys
+// set are added to given so that the table of cmd wins over the keys without
+// table.
+func setConfig(set *flag.FlagSet, p, table, cmd string, values map[string]interface{}, given map[string]bool) error {
+	for _, key := range sortedKeys(values) {
+		v := values[key]
+		if _, ok := v.(map[string]interface{}); ok {
+			if table == "" {
+				continue
+			}
+			return fmt.Errorf("%s: unknown table %s.%s", p, table, key)
+		}
+		if !isConfigFlag(key, table) {
+			if table == "" {
+				return fmt.Errorf("%s: unknown option %s", p, key)
+			}
+			return fmt.Errorf("%s: unknown option %s for %s", p, key, table)
+		}
+		if (table != "" && table != cmd) || set.Lookup(key) == nil || given[key] {
+			continue
+		}
+		vs, ok := v.([]interface{})
+		if !ok {
+			vs = []interface{}{v}
+		}
+		for _, v := range vs {
+			if err := set.Set(key, configValue(v)); err != nil {
+				return fmt.Errorf("%s: %s: %w", p, key, err)
+			}
+		}
+		given[key] = true
+	}
+	return nil
+}
+
+// configValue gives v as it would be written on the command line.
+func configValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		if h, m, s := v.Clock(); h == 0 && m == 0 && s == 0 && v.Nanosecond() == 0 {
+			return v.Format(TimeFormat)
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// isConfigFlag reports whether key is a flag of the command of table or, when
+// table is empty, of one of the commands accepting -config. The -config flag
+// itself can not be given in the file.
+func isConfigFlag(key, table string) bool {
+	if key == "config" {
+		return false
+	}
+	for n, define := range configCommands {
+		if table != "" && n != table {
+			continue
+		}
+		set := flag.NewFlagSet(n, flag.ContinueOnError)
+		set.SetOutput(ioutil.Discard)
+		define(set)
+		if set.Lookup(key) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
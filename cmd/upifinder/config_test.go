@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testFlags gives a flag set with some of the flags of walk.
+func testFlags() (*flag.FlagSet, *int, *bool, *string, *DayList) {
+	set := flag.NewFlagSet("walk", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	buffer := set.Int("buffer", DefaultBuffer, "buffer")
+	stored := set.Bool("stored", false, "stored")
+	zone := set.String("tz", "", "timezone")
+	var days DayList
+	set.Var(&days, "doy", "days of year")
+	return set, buffer, stored, zone, &days
+}
+
+const testConfig = `
+# options of all the sub commands
+tz = "Europe/Brussels" # inline comment
+doy = [58, "59,60"]
+buffer = 1024
+# only used by check
+reset = 1000
+
+[walk]
+buffer = 4096
+stored = true
+
+[check]
+reset = 10
+`
+
+func TestLoadConfig(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "upifinder.conf")
+	if err := ioutil.WriteFile(p, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	set, buffer, stored, zone, days := testFlags()
+	set.Parse(nil)
+	if err := loadConfig(set, p, "walk"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *buffer != 4096 || !*stored || *zone != "Europe/Brussels" || !reflect.DeepEqual(*days, DayList{58, 59, 60}) {
+		t.Errorf("config not applied: buffer=%d stored=%t tz=%s doy=%v", *buffer, *stored, *zone, *days)
+	}
+
+	// the table of another command is ignored.
+	set, buffer, stored, _, _ = testFlags()
+	set.Parse(nil)
+	if err := loadConfig(set, p, "files"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *buffer != 1024 || *stored {
+		t.Errorf("table of walk applied to files: buffer=%d stored=%t", *buffer, *stored)
+	}
+
+	// the flags given on the command line win.
+	set, buffer, stored, zone, days = testFlags()
+	set.Parse([]string{"-buffer", "16", "-tz", "UTC", "-doy", "1"})
+	if err := loadConfig(set, p, "walk"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *buffer != 16 || !*stored || *zone != "UTC" || !reflect.DeepEqual(*days, DayList{1}) {
+		t.Errorf("command line not kept: buffer=%d stored=%t tz=%s doy=%v", *buffer, *stored, *zone, *days)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	data := []string{
+		"bufer = 10",
+		"[walk]\nbufer = 10",
+		"[walk]\nreset = 10",
+		"[unknown]\nbuffer = 10",
+		"[walk.sub]\nbuffer = 10",
+		"[walk\nbuffer = 10",
+		"buffer",
+		"buffer =",
+		"[walk]\nbuffer = \"ten\"",
+		"[walk]\nbuffer = ten",
+		"doy = [58, 400]",
+		"config = \"other.conf\"",
+	}
+	dir := t.TempDir()
+	for i, d := range data {
+		p := filepath.Join(dir, "upifinder.conf")
+		if err := ioutil.WriteFile(p, []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+		set, _, _, _, _ := testFlags()
+		set.Parse(nil)
+		if err := loadConfig(set, p, "walk"); err == nil {
+			t.Errorf("%d: %q: expected error", i, d)
+		}
+	}
+	set, _, _, _, _ := testFlags()
+	if err := loadConfig(set, filepath.Join(dir, "missing.conf"), "walk"); err == nil {
+		t.Errorf("missing file: expected error")
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	data := []struct {
+		Value interface{}
+		Want  string
+	}{
+		{Value: "XYZ", Want: "XYZ"},
+		{Value: true, Want: "true"},
+		{Value: int64(-10), Want: "-10"},
+		{Value: 1.5, Want: "1.5"},
+		{Value: time.Date(2019, 2, 27, 0, 0, 0, 0, time.UTC), Want: "2019-02-27"},
+		{Value: time.Date(2019, 2, 27, 10, 30, 0, 0, time.UTC), Want: "2019-02-27T10:30:00Z"},
+	}
+	for i, d := range data {
+		if got := configValue(d.Value); got != d.Want {
+			t.Errorf("%d: want %s, got %s", i, d.Want, got)
+		}
+	}
+}
+
+func TestIsConfigFlag(t *testing.T) {
+	data := []struct {
+		Key   string
+		Table string
+		Want  bool
+	}{
+		{Key: "buffer", Want: true},
+		{Key: "reset", Want: true},
+		{Key: "reset", Table: "check", Want: true},
+		{Key: "reset", Table: "walk"},
+		{Key: "u", Table: "files", Want: true},
+		{Key: "config"},
+		{Key: "bufer"},
+	}
+	for i, d := range data {
+		if got := isConfigFlag(d.Key, d.Table); got != d.Want {
+			t.Errorf("%d: %s (%s): want %t, got %t", i, d.Key, d.Table, d.Want, got)
+		}
+	}
+}
@@ -2,11 +2,20 @@ package main
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/midbel/cli"
@@ -38,23 +47,66 @@ type Digest struct {
 	Sum      []byte
 	Time     uint64
 	Sequence uint32
+	// Size is the number of bytes hashed.
+	Size int64
 }
 
 var digestCommand = &cli.Command{
-	Usage: "digest [-c] <datadir>",
+	Usage: "digest [-c] [-leap] [-workers] [-q] <datadir>",
 	Alias: []string{"sum", "cksum"},
 	Short: "compute the md5 checksum of all files under the given directory",
 	Run:   runDigest,
+	Desc: `"digest" (sum, cksum) computes the checksum of the data of each file (and of
+each member of the tar archives) found under the given directory.
+
+A summary (number of files, bytes hashed, number of files by magic, elapsed
+time and throughput) is printed on stderr at the end. On SIGINT (Ctrl-C) or
+SIGTERM, the files not yet read are skipped and the summary of the files
+already hashed is printed.
+
+The checksums are printed in the order the files are found, even when the
+members of the tar archives are hashed concurrently (see -workers). The files
+and the members that can not be read are reported on stderr and skipped.
+
+Options:
+
+  -c         print the results as csv
+  -leap      apply the leap seconds when converting time from GPS (default no)
+  -workers N  number of members of the tar archives hashed at the same time
+             (default number of CPU)
+  -q         do not print the summary`,
 }
 
 func runDigest(cmd *cli.Command, args []string) error {
 	csv := cmd.Flag.Bool("c", false, "csv")
+	workers := cmd.Flag.Int("workers", runtime.NumCPU(), "workers")
+	quiet := cmd.Flag.Bool("q", false, "quiet")
+	leap := cmd.Flag.Bool("leap", false, "leap seconds")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	line := Line(*csv)
-	for d := range retrPaths(cmd.Flag.Arg(0)) {
-		w := GPS.Add(time.Duration(d.Time))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var (
+		line = Line(*csv)
+		sum  = newDigestSummary()
+	)
+	for d := range retrPaths(ctx, cmd.Flag.Arg(0), *workers) {
+		sum.Update(d)
+		w := gpsToTime(time.Duration(d.Time), *leap)
 
 		line.AppendBytes(bytes.Trim(d.Magic[:], "\x00"), 4, linewriter.Text)
 		line.AppendUint(uint64(d.Sequence), 8, linewriter.AlignRight)
@@ -64,91 +116,275 @@ func runDigest(cmd *cli.Command, args []string) error {
 
 		io.Copy(os.Stdout, line)
 	}
+	if !*quiet {
+		sum.Print(os.Stderr, ctx.Err() != nil)
+	}
 	return nil
 }
 
+// digestSummary gives the totals of a run of digest.
+type digestSummary struct {
+	Files  uint64
+	Bytes  uint64
+	Magics map[string]uint64
+	Starts time.Time
+}
+
+func newDigestSummary() *digestSummary {
+	return &digestSummary{
+		Magics: make(map[string]uint64),
+		Starts: time.Now(),
+	}
+}
+
+func (s *digestSummary) Update(d *Digest) {
+	s.Files++
+	s.Bytes += uint64(d.Size)
+	s.Magics[string(bytes.Trim(d.Magic[:], "\x00 "))]++
+}
+
+func (s *digestSummary) Print(w io.Writer, interrupted bool) {
+	elapsed := time.Since(s.Starts)
+	if interrupted {
+		fmt.Fprintln(w, "interrupted: partial summary")
+	}
+	fmt.Fprintf(w, "files: %d\n", s.Files)
+	fmt.Fprintf(w, "bytes: %d\n", s.Bytes)
+
+	ms := make([]string, 0, len(s.Magics))
+	for m := range s.Magics {
+		ms = append(ms, m)
+	}
+	sort.Strings(ms)
+	for _, m := range ms {
+		fmt.Fprintf(w, "magic %s: %d\n", m, s.Magics[m])
+	}
+	fmt.Fprintf(w, "elapsed: %s\n", elapsed.Round(time.Millisecond))
+	if secs := elapsed.Seconds(); secs > 0 {
+		fmt.Fprintf(w, "throughput: %.2fMB/s\n", float64(s.Bytes)/secs/(1<<20))
+	}
+}
+
+// header is the beginning of the files: their magic, their sequence counter
+// and their acquisition time (GPS time).
+type header struct {
+	Magic    [4]byte
+	Sequence uint32
+	Time     uint64
+}
+
+func readHeader(r io.Reader) (header, error) {
+	var h header
+	err := binary.Read(r, binary.BigEndian, &h)
+	return h, err
+}
+
 func digestReader(r io.Reader) (*Digest, error) {
-	var d Digest
-	if _, err := r.Read(d.Magic[:]); err != nil {
+	h, err := readHeader(r)
+	if err != nil {
 		return nil, err
 	}
-	var buffer bytes.Buffer
-	if _, err := io.CopyN(&buffer, r, skipBytes(d.Magic[:])); err != nil {
+	// the header can be longer than the fields of header depending on the
+	// magic.
+	if _, err := io.CopyN(ioutil.Discard, r, skipBytes(h.Magic[:])-12); err != nil {
 		return nil, err
 	}
 	digest := xxh.New64(0)
-	if _, err := io.Copy(digest, r); err != nil {
+	n, err := io.Copy(digest, r)
+	if err != nil {
 		return nil, err
 	}
-	d.Sum = digest.Sum(nil)
+	d := Digest{
+		Magic:    h.Magic,
+		Sequence: h.Sequence,
+		Time:     h.Time,
+		Sum:      digest.Sum(nil),
+		Size:     n,
+	}
+	return &d, nil
+}
 
-	binary.Read(&buffer, binary.BigEndian, &d.Sequence)
-	binary.Read(&buffer, binary.BigEndian, &d.Time)
+// readHeaderTime reads the acquisition time (GPS time) written in the header
+// of the file p. The leap seconds are not applied.
+func readHeaderTime(p string) (time.Time, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer r.Close()
 
-	return &d, nil
+	h, err := readHeader(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return gpsToTime(time.Duration(h.Time), false), nil
 }
 
-func retrPaths(base string) <-chan *Digest {
-	q := make(chan *Digest)
+// joinHeaderTimes replaces the acquisition time of the files of queue, read
+// from their filenames, by the time written in their headers. The reception
+// time is moved by the same amount. Only the files found on the filesystem
+// can be read: the other files, and the files whose header can not be read,
+// keep the time of their filename.
+func joinHeaderTimes(queue <-chan *File) <-chan *File {
+	q := make(chan *File)
+	go func() {
+		defer close(q)
+		for f := range queue {
+			if f.Provenance == ProvLoose && f.Valid() {
+				if t, err := readHeaderTime(f.Path); err == nil {
+					delta := f.RecTime.Sub(f.AcqTime)
+					f.AcqTime, f.RecTime = t, t.Add(delta)
+				} else {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", f.Path, err)
+				}
+			}
+			q <- f
+		}
+	}()
+	return q
+}
+
+// member is a member of a tar archive read in memory to be hashed by one of the
+// workers of retrPaths. Its checksum (nil when it can not be computed) is sent
+// to Digest.
+type member struct {
+	Name   string
+	Data   []byte
+	Digest chan *Digest
+}
+
+// retrPaths computes the checksums of the files under base. The checksums are
+// given in the order the files (and the members of the tar archives) are found.
+// The files that can not be read are reported on stderr and skipped. The walk
+// stops when ctx is cancelled but the checksums already computed are still
+// given.
+func retrPaths(ctx context.Context, base string, workers int) <-chan *Digest {
+	if workers <= 0 {
+		workers = 1
+	}
+	var (
+		q  = make(chan *Digest)
+		ms = make(chan member, workers)
+		// pending gives the checksums in the order of the files found while
+		// the members are hashed by the workers.
+		pending = make(chan chan *Digest, workers)
+	)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for m := range ms {
+				d, err := digestReader(bytes.NewReader(m.Data))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", m.Name, err)
+				} else {
+					d.File = filepath.Base(m.Name)
+				}
+				m.Digest <- d
+			}
+		}()
+	}
 	go func() {
 		defer close(q)
+		for c := range pending {
+			if d := <-c; d != nil {
+				q <- d
+			}
+		}
+	}()
+	go func() {
+		defer func() {
+			close(ms)
+			close(pending)
+		}()
 		filepath.Walk(base, func(p string, i os.FileInfo, err error) error {
 			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
 				return err
 			}
 			if i.IsDir() {
 				return nil
 			}
-			if filepath.Ext(p) == ".xml" {
-				return nil
-			}
 			switch e := filepath.Ext(p); e {
 			case ".xml":
 			case ".tar":
-				r, err := os.Open(p)
-				if err != nil {
-					return err
-				}
-				defer r.Close()
-
-				tr := tar.NewReader(r)
-				for {
-					h, err := tr.Next()
-					if err == io.EOF {
-						break
-					}
-					if err != nil {
-						return err
-					}
-					if filepath.Ext(h.Name) == ".xml" {
-						continue
-					}
-					d, err := digestReader(io.LimitReader(tr, h.Size))
-					if err != nil {
-						return err
-					}
-					d.File = filepath.Base(h.Name)
-					q <- d
-				}
+				err = digestMembers(ctx, p, ms, pending)
 			default:
-				r, err := os.Open(p)
-				if err != nil {
-					return err
+				var d *Digest
+				if d, err = digestFile(p); err == nil {
+					c := make(chan *Digest, 1)
+					c <- d
+					pending <- c
 				}
-				defer r.Close()
-				d, err := digestReader(r)
-				if err != nil {
-					return err
-				}
-				d.File = filepath.Base(p)
-				q <- d
 			}
-			return nil
+			if err != nil && err != ctx.Err() {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+				return nil
+			}
+			return err
 		})
 	}()
 	return q
 }
 
+// digestFile computes the checksum of the file p.
+func digestFile(p string) (*Digest, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	d, err := digestReader(r)
+	if err != nil {
+		return nil, err
+	}
+	d.File = filepath.Base(p)
+	return d, nil
+}
+
+// digestMembers reads the members of the tar archive p and sends them to ms to
+// be hashed by the workers of retrPaths, and their checksums to pending. The
+// members read before an error are still hashed.
+func digestMembers(ctx context.Context, p string, ms chan<- member, pending chan<- chan *Digest) error {
+	r, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if filepath.Ext(h.Name) == ".xml" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// the members are hashed by the workers while the next ones are
+		// read.
+		data, err := ioutil.ReadAll(io.LimitReader(tr, h.Size))
+		if err != nil {
+			return err
+		}
+		m := member{
+			Name:   h.Name,
+			Data:   data,
+			Digest: make(chan *Digest, 1),
+		}
+		pending <- m.Digest
+		ms <- m
+	}
+}
+
 func skipBytes(magic []byte) int64 {
 	skip := 12
 	switch {
@@ -181,3 +417,61 @@ func skipBytes(magic []byte) int64 {
 	}
 	return int64(skip)
 }
+
+// readDigests reads a manifest produced by the digest command (as csv or as
+// table) and gives the checksum of each file by its filename.
+func readDigests(p string) (map[string]string, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ds := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		sep := ","
+		if strings.Contains(line, "|") {
+			sep = "|"
+		}
+		ps := strings.Split(line, sep)
+		if len(ps) < 5 {
+			continue
+		}
+		n := strings.TrimSpace(ps[len(ps)-1])
+		ds[filepath.Base(n)] = strings.TrimSpace(ps[len(ps)-2])
+	}
+	return ds, s.Err()
+}
+
+// joinDigests sets the checksum of the files of queue found in ds.
+func joinDigests(queue <-chan *File, ds map[string]string) <-chan *File {
+	q := make(chan *File)
+	go func() {
+		defer close(q)
+		for f := range queue {
+			f.Digest = ds[filepath.Base(f.Path)]
+			q <- f
+		}
+	}()
+	return q
+}
+
+// countUnchecked forwards the files of queue and counts by UPI the ones that
+// have no checksum. The returned map can be safely read once the returned
+// channel is closed.
+func countUnchecked(queue <-chan *File) (<-chan *File, map[string]uint64) {
+	q := make(chan *File)
+	cs := make(map[string]uint64)
+	go func() {
+		defer close(q)
+		for f := range queue {
+			if f.Digest == "" {
+				cs[f.String()]++
+			}
+			q <- f
+		}
+	}()
+	return q, cs
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testData gives the content of a MMA file with the sequence counter seq and
+// size bytes of data.
+func testData(seq uint32, size int) []byte {
+	var buf bytes.Buffer
+	buf.Write(MMA)
+	binary.Write(&buf, binary.BigEndian, seq)
+	binary.Write(&buf, binary.BigEndian, uint64(seq)*1e9)
+	for i := 0; i < size; i++ {
+		buf.WriteByte(byte(int(seq) + i))
+	}
+	return buf.Bytes()
+}
+
+// testTar writes the tar archive p with n members of size bytes of data.
+func testTar(t testing.TB, p string, n, size int) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		data := testData(uint32(i), size)
+		h := tar.Header{
+			Name: fmt.Sprintf("member_%04d.dat", i),
+			Mode: 0644,
+			Size: int64(len(data)),
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetrPaths(t *testing.T) {
+	dir := t.TempDir()
+	testTar(t, filepath.Join(dir, "a.tar"), 50, 1024)
+	ioutil.WriteFile(filepath.Join(dir, "b.dat"), testData(100, 10), 0644)
+	// too short to be hashed: reported and skipped like the members of the
+	// tar archives.
+	ioutil.WriteFile(filepath.Join(dir, "c.dat"), []byte("MMA "), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "d.dat"), testData(101, 10), 0644)
+	// not a tar archive: none of its members can be read.
+	ioutil.WriteFile(filepath.Join(dir, "e.tar"), []byte("not a tar archive"), 0644)
+
+	defer func(w *os.File) { os.Stderr = w }(os.Stderr)
+	os.Stderr, _ = os.Open(os.DevNull)
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		want = append(want, fmt.Sprintf("member_%04d.dat", i))
+	}
+	want = append(want, "b.dat", "d.dat")
+
+	var sums []*Digest
+	for _, w := range []int{1, 4, 16} {
+		var ds []*Digest
+		for d := range retrPaths(context.Background(), dir, w) {
+			ds = append(ds, d)
+		}
+		if len(ds) != len(want) {
+			t.Errorf("%d workers: want %d checksums, got %d", w, len(want), len(ds))
+			continue
+		}
+		for i, d := range ds {
+			if d.File != want[i] {
+				t.Errorf("%d workers: checksum %d: want %s, got %s", w, i, want[i], d.File)
+			}
+			if i < 50 && d.Sequence != uint32(i) {
+				t.Errorf("%d workers: %s: want sequence %d, got %d", w, d.File, i, d.Sequence)
+			}
+		}
+		if sums == nil {
+			sums = ds
+			continue
+		}
+		for i, d := range ds {
+			if !bytes.Equal(d.Sum, sums[i].Sum) || d.Size != sums[i].Size {
+				t.Errorf("%d workers: %s: checksum differs", w, d.File)
+			}
+		}
+	}
+}
+
+func TestDigestSummary(t *testing.T) {
+	dir := t.TempDir()
+	testTar(t, filepath.Join(dir, "a.tar"), 10, 100)
+	ioutil.WriteFile(filepath.Join(dir, "b.dat"), testData(100, 10), 0644)
+
+	sum := newDigestSummary()
+	for d := range retrPaths(context.Background(), dir, 2) {
+		sum.Update(d)
+	}
+	var png Digest
+	copy(png.Magic[:], "PNG")
+	png.Size = 5
+	sum.Update(&png)
+
+	if sum.Files != 12 || sum.Bytes != 10*100+10+5 {
+		t.Errorf("want 12 files of 1015 bytes, got %d files of %d bytes", sum.Files, sum.Bytes)
+	}
+	var buf bytes.Buffer
+	sum.Print(&buf, false)
+	out := buf.String()
+	for _, s := range []string{"files: 12\n", "bytes: 1015\n", "magic MMA: 11\nmagic PNG: 1\n", "elapsed: "} {
+		if !strings.Contains(out, s) {
+			t.Errorf("summary without %q:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "interrupted") {
+		t.Errorf("summary of a complete run marked interrupted:\n%s", out)
+	}
+	buf.Reset()
+	sum.Print(&buf, true)
+	if !strings.HasPrefix(buf.String(), "interrupted: partial summary\n") {
+		t.Errorf("summary of an interrupted run not marked:\n%s", buf.String())
+	}
+}
+
+func TestRetrPathsCancel(t *testing.T) {
+	const n = 500
+	dir := t.TempDir()
+	testTar(t, filepath.Join(dir, "a.tar"), n, 64)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range retrPaths(ctx, dir, 4) {
+		t.Fatalf("canceled before the walk: want no checksum")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		count int
+		done  = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for d := range retrPaths(ctx, dir, 4) {
+			// the checksums already computed are still given in order.
+			if d.Sequence != uint32(count) {
+				t.Errorf("want sequence %d, got %d", count, d.Sequence)
+			}
+			if count++; count == 10 {
+				cancel()
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("retrPaths not stopped once canceled")
+	}
+	if count < 10 || count >= n {
+		t.Errorf("want walk stopped after 10 checksums, got %d", count)
+	}
+}
+
+func BenchmarkRetrPaths(b *testing.B) {
+	dir := b.TempDir()
+	testTar(b, filepath.Join(dir, "archive.tar"), 2000, 32<<10)
+
+	ws := []int{1, 4}
+	if n := runtime.NumCPU(); n > 4 {
+		ws = append(ws, n)
+	}
+	for _, w := range ws {
+		b.Run(fmt.Sprintf("workers-%d", w), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for range retrPaths(context.Background(), dir, w) {
+				}
+			}
+		})
+	}
+}
+
+func TestJoinHeaderTimes(t *testing.T) {
+	dir := t.TempDir()
+	day := testArchive(t, dir)
+
+	// the filename gives 10:10:10, the header one hour and a half earlier.
+	var (
+		name = "0038_XYZ_1_10_20190227_101010_00.dat"
+		acq  = time.Date(2019, 2, 27, 8, 40, 10, 0, time.UTC)
+		buf  bytes.Buffer
+	)
+	h := header{Sequence: 10, Time: uint64(acq.Sub(GPS))}
+	copy(h.Magic[:], MMA)
+	binary.Write(&buf, binary.BigEndian, h)
+	buf.WriteString("payload")
+	if err := ioutil.WriteFile(filepath.Join(day, name), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil || got != h {
+		t.Fatalf("want header %+v, got %+v (%v)", h, got, err)
+	}
+	d, err := digestReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.Sequence != h.Sequence || d.Time != h.Time || d.Size != int64(len("payload")) {
+		t.Errorf("unexpected digest %+v", *d)
+	}
+
+	var fs []*File
+	for f := range joinHeaderTimes(walkFiles([]string{filepath.Join(dir, "38")}, scanOptions{Parallel: 1})) {
+		fs = append(fs, f)
+	}
+	if len(fs) != 1 {
+		t.Fatalf("want 1 file, got %d", len(fs))
+	}
+	f := fs[0]
+	if !f.AcqTime.Equal(acq) {
+		t.Errorf("want acquisition time %s, got %s", acq, f.AcqTime)
+	}
+	if delta := f.RecTime.Sub(f.AcqTime); delta != deltaFromSource(strings.Split(name, "_")) {
+		t.Errorf("reception time not moved with acquisition time: delta %s", delta)
+	}
+}
+
+func TestReadDigests(t *testing.T) {
+	manifest := []string{
+		// csv
+		"MMA ,       1,2019-02-27T10:10:01Z,0a0b,0038_XYZ_1.dat",
+		// table with the path of the file
+		" MMA  |        2 | 2019-02-27T10:10:02Z | 0c0d | archive/0038_XYZ_2.dat ",
+		// too short to be a checksum
+		"MMA ,3,0e0f",
+		"",
+	}
+	p := filepath.Join(t.TempDir(), "digests.txt")
+	if err := ioutil.WriteFile(p, []byte(strings.Join(manifest, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := readDigests(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := map[string]string{
+		"0038_XYZ_1.dat": "0a0b",
+		"0038_XYZ_2.dat": "0c0d",
+	}
+	if len(ds) != len(want) {
+		t.Errorf("want %d checksums, got %d (%v)", len(want), len(ds), ds)
+	}
+	for n, s := range want {
+		if ds[n] != s {
+			t.Errorf("%s: want checksum %q, got %q", n, s, ds[n])
+		}
+	}
+	if _, err := readDigests(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("missing manifest: want error, got none")
+	}
+}
+
+func TestCountUnchecked(t *testing.T) {
+	fs := []*File{
+		testFile("AAA", 1, false),
+		testFile("AAA", 2, false),
+		testFile("AAA", 3, false),
+		testFile("BBB", 1, false),
+	}
+	ds := map[string]string{
+		filepath.Base(fs[0].Path): "0a0b",
+		filepath.Base(fs[2].Path): "0c0d",
+	}
+	q, cs := countUnchecked(joinDigests(feedFiles(fs), ds))
+	var n int
+	for f := range q {
+		if want := ds[filepath.Base(f.Path)]; f.Digest != want {
+			t.Errorf("%s: want checksum %q, got %q", f.Path, want, f.Digest)
+		}
+		n++
+	}
+	if n != len(fs) {
+		t.Errorf("want %d files, got %d", len(fs), n)
+	}
+	want := map[string]uint64{"38/AAA": 1, "38/BBB": 1}
+	if len(cs) != len(want) {
+		t.Errorf("want %d UPI, got %d (%v)", len(want), len(cs), cs)
+	}
+	for u, c := range want {
+		if cs[u] != c {
+			t.Errorf("%s: want %d unchecked files, got %d", u, c, cs[u])
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/midbel/linewriter"
+)
+
+// dropWriter writes one row per file dropped when its filename is parsed with
+// the code of the reason (see -drops). A nil dropWriter writes nothing.
+type dropWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func newDropWriter(w io.Writer) *dropWriter {
+	return &dropWriter{w: w}
+}
+
+// dropCode gives the code written for the reason err why a file is dropped.
+func dropCode(err error) string {
+	switch {
+	case errors.Is(err, ErrName):
+		return "name"
+	case errors.Is(err, ErrPattern):
+		return "pattern"
+	case errors.Is(err, ErrType):
+		return "type"
+	case errors.Is(err, ErrOrigin):
+		return "origin"
+	case errors.Is(err, ErrSource):
+		return "source"
+	case errors.Is(err, ErrFields):
+		return "fields"
+	case errors.Is(err, ErrSequence):
+		return "sequence"
+	case errors.Is(err, ErrTime):
+		return "time"
+	default:
+		return "parse"
+	}
+}
+
+func (d *dropWriter) drop(p string, err error) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	line := Line(true)
+	line.AppendString(p, 0, linewriter.AlignLeft)
+	line.AppendString(dropCode(err), 0, linewriter.AlignLeft)
+	line.AppendString(err.Error(), 0, linewriter.AlignLeft)
+	io.Copy(d.w, line)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDropCode(t *testing.T) {
+	data := []struct {
+		Err  error
+		Want string
+	}{
+		{Err: ErrName, Want: "name"},
+		{Err: ErrPattern, Want: "pattern"},
+		{Err: fmt.Errorf("%w: 1", ErrType), Want: "type"},
+		{Err: fmt.Errorf("%w: 38", ErrOrigin), Want: "origin"},
+		{Err: fmt.Errorf("%w: zz", ErrSource), Want: "source"},
+		{Err: fmt.Errorf("%w (3)", ErrFields), Want: "fields"},
+		{Err: fmt.Errorf("%w: invalid syntax", ErrSequence), Want: "sequence"},
+		{Err: fmt.Errorf("%w: out of range", ErrTime), Want: "time"},
+		{Err: errors.New("unexpected"), Want: "parse"},
+	}
+	for _, d := range data {
+		if got := dropCode(d.Err); got != d.Want {
+			t.Errorf("%s: want code %s, got %s", d.Err, d.Want, got)
+		}
+	}
+}
+
+func TestDropWriter(t *testing.T) {
+	want := map[string]string{
+		"0038 XYZ_1_10_20190227_101010_00.dat": "name",
+		"00zz_XYZ_1_10_20190227_101010_00.dat": "source",
+		"004a_XYZ_1_10_20190227_101010_00.dat": "origin",
+		"0038_XYZ_20190227_101010_00.dat":      "fields",
+	}
+	names := testNames("XYZ", 3)
+	for n := range want {
+		names = append(names, n)
+	}
+	dir := t.TempDir()
+	testArchive(t, dir, names...)
+
+	defer func(f *os.File) { os.Stderr = f }(os.Stderr)
+	os.Stderr, _ = os.Open(os.DevNull)
+
+	var buf bytes.Buffer
+	opts := scanOptions{Parallel: 4, Drops: newDropWriter(&buf)}
+	if fs := collectPaths(walkFiles([]string{dir}, opts)); len(fs) != 3 {
+		t.Errorf("want 3 files, got %d", len(fs))
+	}
+	rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(rows) != len(want) {
+		t.Fatalf("want %d files dropped, got %d", len(want), len(rows))
+	}
+	for _, r := range rows {
+		vs := strings.SplitN(r, ",", 3)
+		if len(vs) != 3 {
+			t.Errorf("unexpected row %s", r)
+			continue
+		}
+		n := filepath.Base(strings.TrimSpace(vs[0]))
+		if code := strings.TrimSpace(vs[1]); want[n] != code {
+			t.Errorf("%s: want code %s, got %s", n, want[n], code)
+		}
+	}
+
+	// a nil dropWriter writes nothing.
+	var d *dropWriter
+	d.drop("0038_XYZ.dat", ErrFields)
+}
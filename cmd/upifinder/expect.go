@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/midbel/linewriter"
+)
+
+// readExpected reads the list of UPI that should be found in the archive from
+// the file p. Each line contains either an UPI or a pair source/UPI. Empty
+// lines and lines starting with a # are ignored.
+func readExpected(p string) ([]string, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var vs []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		v := strings.TrimSpace(s.Text())
+		if len(v) == 0 || strings.HasPrefix(v, "#") {
+			continue
+		}
+		vs = append(vs, v)
+	}
+	return vs, s.Err()
+}
+
+// isExpected reports whether the key k (source/UPI) is matched by the
+// expected value e.
+func isExpected(k, e string) bool {
+	if strings.Index(e, "/") >= 0 {
+		return k == e
+	}
+	return k == e || strings.HasSuffix(k, "/"+e)
+}
+
+// expectCozes adds an empty Coze in rs for each UPI of upis that has no file.
+func expectCozes(rs map[string]*Coze, upis []string) {
+	for _, u := range upis {
+		var found bool
+		for _, c := range rs {
+			if found = isExpected(c.UPI, u); found {
+				break
+			}
+		}
+		if !found {
+			rs[u] = &Coze{UPI: u, absent: true}
+		}
+	}
+}
+
+// expectGaps adds an empty Gap to gs for each UPI of upis that has no file.
+func expectGaps(gs []*Gap, keys map[string]struct{}, upis []string) []*Gap {
+	for _, u := range upis {
+		var found bool
+		for k := range keys {
+			if found = isExpected(k, u); found {
+				break
+			}
+		}
+		if !found {
+			gs = append(gs, &Gap{UPI: u, absent: true})
+		}
+	}
+	return gs
+}
+
+// trackKeys forwards the files of queue and records their keys given by by.
+// The returned map can be safely read once the returned channel is closed.
+func trackKeys(queue <-chan *File, by ByFunc) (<-chan *File, map[string]struct{}) {
+	q := make(chan *File)
+	ks := make(map[string]struct{})
+	go func() {
+		defer close(q)
+		for f := range queue {
+			ks[by(f)] = struct{}{}
+			q <- f
+		}
+	}()
+	return q, ks
+}
+
+func appendStatus(line *linewriter.Writer, absent bool) {
+	if absent {
+		line.AppendString("absent", 8, linewriter.AlignRight)
+	} else {
+		line.AppendString("present", 8, linewriter.AlignRight)
+	}
+}
+
+// appendGapStatus appends the status of g: absent, reset (start of a new run),
+// slow (files delivered slower than the expected cadence) or present.
+func appendGapStatus(line *linewriter.Writer, g *Gap) {
+	switch {
+	case g.reset:
+		line.AppendString("reset", 8, linewriter.AlignRight)
+	case g.slow:
+		line.AppendString("slow", 8, linewriter.AlignRight)
+	default:
+		appendStatus(line, g.absent)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadExpected(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "expected.txt")
+	data := "# expected UPI\nAAA\n\n  38/BBB  \n# CCC\n"
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	upis, err := readExpected(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"AAA", "38/BBB"}; !reflect.DeepEqual(upis, want) {
+		t.Errorf("want %v, got %v", want, upis)
+	}
+	if _, err := readExpected(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("missing file: expected error")
+	}
+}
+
+func TestIsExpected(t *testing.T) {
+	data := []struct {
+		Key    string
+		Expect string
+		Want   bool
+	}{
+		{Key: "38/AAA", Expect: "AAA", Want: true},
+		{Key: "38/AAA", Expect: "38/AAA", Want: true},
+		{Key: "38/AAA", Expect: "39/AAA"},
+		{Key: "38/XAAA", Expect: "AAA"},
+		{Key: "38/AAA", Expect: "AA"},
+	}
+	for _, d := range data {
+		if got := isExpected(d.Key, d.Expect); got != d.Want {
+			t.Errorf("%s/%s: want %t, got %t", d.Key, d.Expect, d.Want, got)
+		}
+	}
+}
+
+func TestExpectCozes(t *testing.T) {
+	rs := map[string]*Coze{
+		"38/AAA": testCoze("AAA", 1, 2, 3),
+	}
+	expectCozes(rs, []string{"AAA", "BBB", "39/AAA"})
+	if len(rs) != 3 {
+		t.Fatalf("want 3 UPI, got %d", len(rs))
+	}
+	for _, k := range []string{"BBB", "39/AAA"} {
+		c, ok := rs[k]
+		if !ok || !c.absent || c.Count != 0 {
+			t.Errorf("%s: want absent UPI without file, got %+v", k, c)
+		}
+	}
+	if rs["38/AAA"].absent {
+		t.Errorf("38/AAA: want present")
+	}
+
+	var buf bytes.Buffer
+	reportWalkResults(&buf, sortCozes(rs, true), walkOptions{CSV: true, Expect: true, Now: testEpoch})
+	rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// with -z, the absent UPI are kept but not 38/AAA without missing files.
+	if len(rows) != 2 {
+		t.Fatalf("want 2 rows, got %d", len(rows))
+	}
+	for _, r := range rows {
+		if !strings.HasSuffix(r, "absent") {
+			t.Errorf("want absent UPI, got %s", r)
+		}
+	}
+}
+
+func TestExpectGaps(t *testing.T) {
+	gs := testGaps("AAA", 1, 3)
+	queue, keys := trackKeys(feedFiles([]*File{testFile("AAA", 1, false), testFile("AAA", 3, false)}), byUPI)
+	for range queue {
+	}
+	gs = expectGaps(gs, keys, []string{"AAA", "BBB"})
+	if len(gs) != 2 {
+		t.Fatalf("want 2 gaps, got %d", len(gs))
+	}
+	if g := gs[1]; g.UPI != "BBB" || !g.absent {
+		t.Errorf("want absent gap of BBB, got %+v", *g)
+	}
+
+	var buf bytes.Buffer
+	reportCheckResults(&buf, gs, checkOptions{CSV: true, Status: true})
+	rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(rows) != 2 || !strings.HasSuffix(rows[0], "present") || !strings.HasSuffix(rows[1], "absent") {
+		t.Errorf("unexpected status:\n%s", buf.String())
+	}
+}
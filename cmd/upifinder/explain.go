@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/midbel/cli"
+)
+
+var explainCommand = &cli.Command{
+	Usage: "explain [-u] [-delta] [-origin] [-pattern] [-seq64] <filename,...>",
+	Short: "print the information upifinder extracts from filenames",
+	Run:   runExplain,
+	Desc: `"explain" parses the given filenames the same way the other commands do and
+prints the information found in them (source, UPI, type, sequence counter, times)
+and whether the file is kept or discarded, and why.
+
+The filenames are not read and do not need to exist.
+
+Options:
+
+  -u UPI        use UPI as the UPI of the files
+  -delta WHAT   compute the reception time from the source or the suffix
+  -origin T=LIST  accept the origins given by LIST for the files of type T
+  -pattern RE   parse the filenames with the given regular expression
+  -upi-fields F:L  build the UPI with the fields F to L (excluded) of the filename
+  -seq64        accept sequence counters encoded on 64 bits (default 32 bits)`,
+}
+
+func runExplain(cmd *cli.Command, args []string) error {
+	opts := newParseOptions()
+	upi := cmd.Flag.String("u", "", "upi")
+	delta := cmd.Flag.String("delta", "source", "delta")
+	cmd.Flag.Var(&opts.Fields, "upi-fields", "upi fields")
+	cmd.Flag.BoolVar(&opts.Long, "seq64", false, "64 bits sequence")
+	pattern := cmd.Flag.String("pattern", "", "filename pattern")
+	cmd.Flag.Var(opts.Origins, "origin", "origins by type")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if opts.Delta, err = parseDelta(*delta); err != nil {
+		return err
+	}
+	if opts.Pattern, err = compilePattern(*pattern); err != nil {
+		return err
+	}
+	if cmd.Flag.NArg() == 0 {
+		cmd.Help()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for i, p := range cmd.Flag.Args() {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		explainFilename(w, p, *upi, opts)
+	}
+	return w.Flush()
+}
+
+func explainFilename(w *tabwriter.Writer, p, upi string, opts parseOptions) {
+	f, err := parseName(p, upi, 0, opts)
+
+	fmt.Fprintf(w, "file:\t%s\n", p)
+	if f != nil {
+		fmt.Fprintf(w, "source:\t%s\n", f.Source)
+		fmt.Fprintf(w, "upi:\t%s\n", f.Info)
+		if f.typ != "" {
+			fmt.Fprintf(w, "type:\t%s (origins: %s)\n", f.typ, formatOrigins(opts.origins()[f.typ]))
+		}
+		fmt.Fprintf(w, "sequence:\t%d\n", f.Sequence)
+		if !f.AcqTime.IsZero() {
+			fmt.Fprintf(w, "acqtime:\t%s\n", f.AcqTime.Format(time.RFC3339))
+			fmt.Fprintf(w, "rectime:\t%s\n", f.RecTime.Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "valid:\t%t\n", f.Valid())
+	}
+	switch {
+	case err == nil:
+		fmt.Fprintf(w, "status:\tkept\n")
+	case isDiscarded(err):
+		fmt.Fprintf(w, "status:\tdiscarded (%s)\n", err)
+	default:
+		fmt.Fprintf(w, "status:\terror (%s)\n", err)
+	}
+}
+
+func formatOrigins(vs []int) string {
+	if len(vs) == 0 {
+		return "none"
+	}
+	str := make([]string, len(vs))
+	for i, v := range vs {
+		str[i] = fmt.Sprintf("%x", v)
+	}
+	return strings.Join(str, ",")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/tabwriter"
+)
+
+func TestExplainFilename(t *testing.T) {
+	data := []struct {
+		Name string
+		UPI  string
+		Want []string
+	}{
+		{
+			Name: "0038_XYZ_1_10_20190227_101010_00.dat",
+			Want: []string{
+				"source: 38",
+				"upi: XYZ",
+				"type: 1 (origins: 33,34,37,38,42,43,44,45,46,47)",
+				"sequence: 10",
+				"acqtime: 2019-02-27T10:10:10Z",
+				"rectime: 2019-02-27T10:48:10Z",
+				"valid: true",
+				"status: kept",
+			},
+		},
+		{
+			Name: "0038_XYZ_1_10_20190227_101010_00.dat",
+			UPI:  "ABC",
+			Want: []string{"upi: ABC", "status: kept"},
+		},
+		{
+			Name: "0038_XYZ_3_10_20190227_101010_00.dat",
+			Want: []string{"type: 3 (origins: 35,36,39,40,41,51,90)", "status: discarded (origin not accepted for type)"},
+		},
+		{
+			Name: "0038_XYZ_1_ten_20190227_101010_00.dat",
+			Want: []string{"sequence: 0", "status: error (sequence:"},
+		},
+		{
+			Name: "0038_X:Z_1_10_20190227_101010_00.dat",
+			Want: []string{"status: discarded (unsupported characters in filename)"},
+		},
+	}
+	for _, d := range data {
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 8, 1, ' ', 0)
+		explainFilename(w, d.Name, d.UPI, newParseOptions())
+		w.Flush()
+
+		out := buf.String()
+		if !strings.HasPrefix(out, "file:") || !strings.Contains(out, d.Name) {
+			t.Errorf("%s: filename not printed:\n%s", d.Name, out)
+		}
+		for _, v := range d.Want {
+			ps := strings.SplitN(v, ": ", 2)
+			var found bool
+			for _, line := range strings.Split(out, "\n") {
+				fs := strings.SplitN(line, ":", 2)
+				if len(fs) == 2 && fs[0] == ps[0] && strings.HasPrefix(strings.TrimSpace(fs[1]), ps[1]) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("%s: want %q in\n%s", d.Name, v, out)
+			}
+		}
+	}
+}
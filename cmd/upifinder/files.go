@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+var filesCommand = &cli.Command{
+	Usage: "files [-d] [-s] [-e] [-year] [-doy] [-u] [-c] [-j] [-rename] [-omitempty] [-digest] [-xml] [-seq64] [-no-recurse] [-drops] [-config] [-ignore-ext] <archive,...>",
+	Alias: []string{"list", "ls"},
+	Short: "list the files available in the archive",
+	Run:   runFiles,
+	Desc: `"files" (list, ls) traverse the Hadock archive and print one row per file
+as soon as it is found, without any aggregation.
+
+The period of time is selected with the same rules as the "walk" command.
+
+Options:
+
+  -u UPI     only list files for the given UPI
+  -buffer N  number of files found in advance of their processing (default 1024)
+  -config FILE  read the options not given on the command line from FILE (see
+             configuration file in README)
+  -no-recurse  only find the files directly in the given paths (or in the paths
+             selected for the period) and skip their sub directories
+  -per-root N  walk at most N paths at the same time under each given path
+  -s START   only list files created after START
+  -e END     only list files created before END
+  -d DAYS    only list files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only list files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
+  -c         print the results as csv
+  -j         print the results as json (one object per line)
+  -rename OLD=NEW  rename the field OLD of the json objects to NEW. Can be
+             repeated. NEW can not be the name of another field
+  -omitempty  do not write the fields of the json objects with a zero value
+  -digest FILE  print the checksum of the files found in FILE (a report of digest)
+  -xml       print the mode and quality read from the xml file of each file
+  -delta WHAT   compute the reception time from the source (first field
+                of the filename) or the suffix (last field of the filename)
+                given as a number of minutes (default source)
+  -origin T=LIST  accept the origins given by LIST for the files of type T. LIST
+                  is images, sciences or a list of hexadecimal origins
+  -pattern RE  parse the filenames with the given regular expression instead
+                of splitting them on underscores (see below)
+  -upi-fields F:L  build the UPI with the fields F to L (excluded) of the
+                filename. Negative values count from the end (default 1:-5)
+  -ignore-ext EXT  skip the files with the extension EXT (eg .md5) in addition
+             to the xml files. Can be repeated or given as a comma separated list
+  -drops FILE  write to FILE (csv) the files dropped because their filename can
+             not be parsed or is discarded, with the code of the reason (see
+             README)
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)
+
+Filename pattern:
+
+the regular expression given to -pattern should define the named groups source
+(hexadecimal), upi, sequence and time (YYYYmmddHHMMSS). A date group (YYYYmmdd)
+can be given and then time only contains HHMMSS. The type and delta groups are
+optional and are used to select the accepted origins and to compute the
+reception time.`,
+}
+
+// filesConfig holds the options of files given on the command line or in the
+// configuration file (see -config).
+type filesConfig struct {
+	Start, End When
+	UPI        string
+	Buffer     int
+	Config     string
+	NoRecurse  bool
+	PerRoot    int
+	Period     int
+	Year       int
+	Days       DayList
+	CSV        bool
+	JSON       bool
+	Profile    jsonProfile
+	Delta      string
+	Parse      parseOptions
+	Ignored    ExtList
+	Drops      string
+	Pattern    string
+	Digest     string
+	XML        bool
+}
+
+// newFilesConfig defines the flags of files in set.
+func newFilesConfig(set *flag.FlagSet) *filesConfig {
+	c := filesConfig{
+		Parse:   newParseOptions(),
+		Profile: newJSONProfile(),
+	}
+	set.Var(&c.Start, "s", "start")
+	set.Var(&c.End, "e", "end")
+	set.StringVar(&c.UPI, "u", "", "upi")
+	set.IntVar(&c.Buffer, "buffer", DefaultBuffer, "buffer")
+	set.StringVar(&c.Config, "config", "", "config file")
+	set.BoolVar(&c.NoRecurse, "no-recurse", false, "no recursion")
+	set.IntVar(&c.PerRoot, "per-root", 0, "paths per root")
+	set.IntVar(&c.Period, "d", 0, "period")
+	set.IntVar(&c.Year, "year", 0, "year")
+	set.Var(&c.Days, "doy", "days of year")
+	set.BoolVar(&c.CSV, "c", false, "csv")
+	set.BoolVar(&c.JSON, "j", false, "json")
+	set.Var(c.Profile.Names, "rename", "rename json fields")
+	set.BoolVar(&c.Profile.OmitEmpty, "omitempty", false, "omit empty json fields")
+	set.StringVar(&c.Delta, "delta", "source", "delta")
+	set.Var(&c.Parse.Fields, "upi-fields", "upi fields")
+	set.Var(&c.Ignored, "ignore-ext", "ignored extensions")
+	set.StringVar(&c.Drops, "drops", "", "dropped files")
+	set.BoolVar(&c.Parse.Long, "seq64", false, "64 bits sequence")
+	set.StringVar(&c.Pattern, "pattern", "", "filename pattern")
+	set.Var(c.Parse.Origins, "origin", "origins by type")
+	set.StringVar(&c.Digest, "digest", "", "digest manifest")
+	set.BoolVar(&c.XML, "xml", false, "xml metadata")
+	return &c
+}
+
+func runFiles(cmd *cli.Command, args []string) error {
+	c := newFilesConfig(&cmd.Flag)
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if err := loadConfig(&cmd.Flag, c.Config, "files"); err != nil {
+		return err
+	}
+	var err error
+	if c.Parse.Delta, err = parseDelta(c.Delta); err != nil {
+		return err
+	}
+	if c.Parse.Pattern, err = compilePattern(c.Pattern); err != nil {
+		return err
+	}
+	if c.CSV && c.JSON {
+		return fmt.Errorf("csv and json can not be set together")
+	}
+
+	if cmd.Flag.NArg() == 0 {
+		cmd.Help()
+	}
+
+	var drops *dropWriter
+	if c.Drops != "" {
+		f, err := os.Create(c.Drops)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		drops = newDropWriter(f)
+	}
+
+	var digests map[string]string
+	if c.Digest != "" {
+		ds, err := readDigests(c.Digest)
+		if err != nil {
+			return err
+		}
+		digests = ds
+	}
+	paths, err := selectPaths(cmd.Flag.Args(), c.Period, c.Start.Time, c.End.Time, c.Year, c.Days)
+	if err != nil {
+		return err
+	}
+	queue := walkFiles(paths, scanOptions{
+		UPI:       c.UPI,
+		Parallel:  8,
+		Buffer:    c.Buffer,
+		NoRecurse: c.NoRecurse,
+		PerRoot:   c.PerRoot,
+		Roots:     cmd.Flag.Args(),
+		Ignored:   c.Ignored,
+		Parse:     c.Parse,
+		Drops:     drops,
+	})
+	if digests != nil {
+		queue = joinDigests(queue, digests)
+	}
+	if c.XML {
+		queue = joinMetadata(queue)
+	}
+	if c.JSON {
+		return reportFilesJSON(queue, os.Stdout, c.Profile)
+	}
+	reportFiles(os.Stdout, queue, c.CSV, digests != nil, c.XML)
+	return nil
+}
+
+func reportFiles(w io.Writer, queue <-chan *File, csv, digest, meta bool) {
+	line := Line(csv)
+	for f := range queue {
+		line.AppendString(Transform(f.String()), 24, linewriter.AlignLeft)
+		line.AppendUint(f.Sequence, 10, linewriter.AlignRight)
+		line.AppendTime(f.AcqTime, time.RFC3339, linewriter.AlignRight)
+		line.AppendTime(f.RecTime, time.RFC3339, linewriter.AlignRight)
+		if csv {
+			line.AppendUint(uint64(f.Size), 10, linewriter.AlignRight)
+		} else {
+			line.AppendSize(f.Size, 10, linewriter.AlignRight)
+		}
+		if digest {
+			line.AppendString(orMissing(f.Digest), 16, linewriter.AlignLeft)
+		}
+		if meta {
+			var m Metadata
+			if f.Meta != nil {
+				m = *f.Meta
+			}
+			line.AppendString(orMissing(m.Mode), 12, linewriter.AlignLeft)
+			line.AppendString(orMissing(m.Quality), 12, linewriter.AlignLeft)
+		}
+		line.AppendString(f.Path, 0, linewriter.AlignLeft)
+
+		io.Copy(w, line)
+	}
+}
+
+func reportFilesJSON(queue <-chan *File, w io.Writer, j jsonProfile) error {
+	e := json.NewEncoder(w)
+	for f := range queue {
+		if err := e.Encode(withProfile(f, j)); err != nil {
+			// drain the queue to not leak the goroutines of walkFiles
+			for range queue {
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFiles reads the files written as json by "files -j" in the file p. When
+// upi is set, only the files of this UPI are kept. The reception time of the
+// files written by a version of files without the rectime field is zero.
+func loadFiles(p, upi string) ([]*File, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var (
+		fs []*File
+		d  = json.NewDecoder(r)
+	)
+	for {
+		var f File
+		if err := d.Decode(&f); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if upi != "" && f.Info != upi {
+			continue
+		}
+		fs = append(fs, &f)
+	}
+	return fs, nil
+}
+
+func orMissing(v string) string {
+	if v == "" {
+		return "missing"
+	}
+	return v
+}
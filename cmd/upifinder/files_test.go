@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportFiles(t *testing.T) {
+	fs := []*File{testFile("XYZ", 1, false), testFile("XYZ", 2, false)}
+	fs[0].Digest = "0123456789abcdef"
+	fs[1].Meta = &Metadata{Mode: "science", Quality: "good"}
+
+	data := []struct {
+		Digest bool
+		Meta   bool
+		Cols   int
+	}{
+		{Cols: 6},
+		{Digest: true, Cols: 7},
+		{Meta: true, Cols: 8},
+		{Digest: true, Meta: true, Cols: 9},
+	}
+	for _, d := range data {
+		var buf bytes.Buffer
+		reportFiles(&buf, feedFiles(fs), true, d.Digest, d.Meta)
+		rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(rows) != len(fs) {
+			t.Fatalf("want %d rows, got %d", len(fs), len(rows))
+		}
+		for i, r := range rows {
+			vs := strings.Split(r, ",")
+			if len(vs) != d.Cols {
+				t.Errorf("digest=%t meta=%t: row %d: want %d columns, got %d (%s)", d.Digest, d.Meta, i, d.Cols, len(vs), r)
+				continue
+			}
+			f := fs[i]
+			if strings.TrimSpace(vs[3]) != f.RecTime.Format(time.RFC3339) || strings.TrimSpace(vs[len(vs)-1]) != f.Path {
+				t.Errorf("row %d: unexpected row %s", i, r)
+			}
+		}
+		if d.Digest && d.Meta {
+			if vs := strings.Split(rows[1], ","); strings.TrimSpace(vs[5]) != "missing" || strings.TrimSpace(vs[6]) != "science" {
+				t.Errorf("want missing digest and science mode, got %s", rows[1])
+			}
+		}
+	}
+}
+
+func TestLoadFilesRecTime(t *testing.T) {
+	fs := []*File{testFile("XYZ", 1, false), testFile("XYZ", 2, false)}
+	for i, f := range fs {
+		f.RecTime = f.AcqTime.Add(time.Duration(i+1) * time.Hour)
+		f.Provenance = ProvLoose
+	}
+
+	var buf bytes.Buffer
+	if err := reportFilesJSON(feedFiles(fs), &buf, newJSONProfile()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	p := filepath.Join(t.TempDir(), "files.json")
+	if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := loadFiles(p, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rs) != len(fs) {
+		t.Fatalf("want %d files, got %d", len(fs), len(rs))
+	}
+	for i, f := range rs {
+		if !f.RecTime.Equal(fs[i].RecTime) || !f.AcqTime.Equal(fs[i].AcqTime) {
+			t.Errorf("file %d: want times %s/%s, got %s/%s", i, fs[i].AcqTime, fs[i].RecTime, f.AcqTime, f.RecTime)
+		}
+		f.AcqTime, f.RecTime = fs[i].AcqTime, fs[i].RecTime
+		g := *fs[i]
+		g.typ = ""
+		if !reflect.DeepEqual(*f, g) {
+			t.Errorf("file %d: want %+v, got %+v", i, g, *f)
+		}
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	var fs []*File
+	for _, s := range []uint64{1, 2, 5, 6, 9} {
+		fs = append(fs, testFile("AAA", s, false), testFile("BBB", s+1, false))
+	}
+	var buf bytes.Buffer
+	if err := reportFilesJSON(feedFiles(fs), &buf, newJSONProfile()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dir := t.TempDir()
+	p := filepath.Join(dir, "files.json")
+	if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		UPI   string
+		Files int
+		Gaps  []gapBounds
+	}{
+		{Files: 10, Gaps: []gapBounds{{2, 5}, {6, 9}, {3, 6}, {7, 10}}},
+		{UPI: "AAA", Files: 5, Gaps: []gapBounds{{2, 5}, {6, 9}}},
+		{UPI: "CCC"},
+	}
+	for _, d := range data {
+		rs, err := loadFiles(p, d.UPI)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", d.UPI, err)
+			continue
+		}
+		if len(rs) != d.Files {
+			t.Errorf("%q: want %d files, got %d", d.UPI, d.Files, len(rs))
+			continue
+		}
+		// the files loaded are checked like the files found in the archive.
+		gs := checkFiles(feedFiles(rs), 0, false, 0, byUPI)
+		sortGaps(gs, false)
+		compareGaps(t, gs, d.Gaps)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(invalid, append(buf.Bytes(), "{\"upi\":"...), 0644)
+	if _, err := loadFiles(invalid, ""); err == nil || !strings.HasPrefix(err.Error(), invalid) {
+		t.Errorf("invalid json: want error prefixed by the file, got %v", err)
+	}
+	if _, err := loadFiles(filepath.Join(dir, "missing.json"), ""); err == nil {
+		t.Errorf("missing file: want error, got none")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+)
+
+// leapSeconds gives the dates (UTC) at which a leap second has been inserted
+// since the GPS epoch.
+var leapSeconds = []time.Time{
+	time.Date(1981, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1982, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1983, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1985, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1988, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1992, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1993, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1994, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1996, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1997, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2012, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// leapAt gives the number of leap seconds inserted between the GPS epoch and t
+// (UTC).
+func leapAt(t time.Time) time.Duration {
+	var n time.Duration
+	for _, s := range leapSeconds {
+		if t.Before(s) {
+			break
+		}
+		n++
+	}
+	return n * time.Second
+}
+
+// timeToGPS gives the seconds elapsed between the GPS epoch and t. The leap
+// seconds are only counted when leap is set (see -leap): the GPS times are
+// otherwise seconds elapsed since the GPS epoch without any correction.
+func timeToGPS(t time.Time, leap bool) uint64 {
+	left := t.Sub(UNIX).Seconds()
+	right := GPS.Sub(UNIX).Seconds()
+	if leap {
+		left += leapAt(t).Seconds()
+	}
+	return uint64(left - right)
+}
+
+// gpsToTime gives the UTC time of d elapsed since the GPS epoch, corrected by
+// the leap seconds when leap is set (see timeToGPS).
+func gpsToTime(d time.Duration, leap bool) time.Time {
+	w := GPS.Add(d)
+	if leap {
+		// the leap seconds are counted at the UTC time that is not yet known
+		// so the count at the uncorrected time is used as a first guess.
+		w = w.Add(-leapAt(w.Add(-leapAt(w))))
+	}
+	return w
+}
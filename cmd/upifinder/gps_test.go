@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeToGPS(t *testing.T) {
+	data := []struct {
+		When time.Time
+		Leap uint64
+	}{
+		{When: GPS, Leap: 0},
+		{When: time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC), Leap: 17},
+		{When: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), Leap: 18},
+		{When: time.Date(2019, 2, 27, 10, 10, 10, 0, time.UTC), Leap: 18},
+	}
+	for _, d := range data {
+		raw := uint64(d.When.Sub(GPS) / time.Second)
+
+		if got := timeToGPS(d.When, false); got != raw {
+			t.Errorf("%s: no leap: want %d, got %d", d.When, raw, got)
+		}
+		if got := gpsToTime(time.Duration(raw)*time.Second, false); !got.Equal(d.When) {
+			t.Errorf("%s: no leap: want %s back, got %s", d.When, d.When, got)
+		}
+
+		want := raw + d.Leap
+		if got := timeToGPS(d.When, true); got != want {
+			t.Errorf("%s: leap: want %d, got %d", d.When, want, got)
+		}
+		if got := gpsToTime(time.Duration(want)*time.Second, true); !got.Equal(d.When) {
+			t.Errorf("%s: leap: want %s back, got %s", d.When, d.When, got)
+		}
+	}
+}
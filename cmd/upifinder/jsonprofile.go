@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Renames maps the names of the fields of the json objects to the names to
+// use instead. It is given as OLD=NEW and the flag can be repeated.
+type Renames map[string]string
+
+func (r Renames) Set(v string) error {
+	ps := strings.SplitN(v, "=", 2)
+	if len(ps) != 2 || ps[0] == "" || ps[1] == "" {
+		return fmt.Errorf("invalid rename %s (OLD=NEW)", v)
+	}
+	r[ps[0]] = ps[1]
+	return nil
+}
+
+func (r Renames) String() string {
+	return fmt.Sprint(map[string]string(r))
+}
+
+// jsonProfile changes the objects written as json by check and files: their
+// fields can be renamed (see -rename) and the fields with a zero value omitted
+// (see -omitempty).
+type jsonProfile struct {
+	Names     Renames
+	OmitEmpty bool
+}
+
+func newJSONProfile() jsonProfile {
+	return jsonProfile{Names: make(Renames)}
+}
+
+// profiled gives the json of v modified by its jsonProfile.
+type profiled struct {
+	v       interface{}
+	profile jsonProfile
+}
+
+// withProfile returns v unchanged when j is the default profile.
+func withProfile(v interface{}, j jsonProfile) interface{} {
+	if len(j.Names) == 0 && !j.OmitEmpty {
+		return v
+	}
+	return profiled{v: v, profile: j}
+}
+
+// MarshalJSON keeps the fields of v in their order. It fails when a field is
+// renamed to the name of another field.
+func (p profiled) MarshalJSON() ([]byte, error) {
+	bs, err := json.Marshal(p.v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("%T: not a json object", p.v)
+	}
+	var (
+		buf   bytes.Buffer
+		names = make(map[string]string)
+	)
+	buf.WriteByte('{')
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var (
+			k = t.(string)
+			v json.RawMessage
+		)
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		if p.profile.OmitEmpty && isEmptyJSON(v) {
+			continue
+		}
+		n, ok := p.profile.Names[k]
+		if !ok {
+			n = k
+		}
+		if o, ok := names[n]; ok {
+			return nil, fmt.Errorf("rename: %s and %s both named %s", o, k, n)
+		}
+		names[n] = k
+		if len(names) > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(n)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func isEmptyJSON(v json.RawMessage) bool {
+	switch string(v) {
+	case "null", "false", "0", `""`, "[]", "{}", `"0001-01-01T00:00:00Z"`:
+		return true
+	default:
+		return false
+	}
+}
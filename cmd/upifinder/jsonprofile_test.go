@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProfiledJSON(t *testing.T) {
+	v := struct {
+		UPI   string `json:"upi"`
+		First uint64 `json:"first"`
+		Last  uint64 `json:"last"`
+		Empty string `json:"empty"`
+	}{
+		UPI:   "38/XYZ",
+		First: 1,
+		Last:  10,
+	}
+	data := []struct {
+		Names Renames
+		Omit  bool
+		Want  string
+		Fail  bool
+	}{
+		{
+			Want: `{"upi":"38/XYZ","first":1,"last":10,"empty":""}`,
+		},
+		{
+			Names: Renames{"upi": "instrument", "last": "end"},
+			Want:  `{"instrument":"38/XYZ","first":1,"end":10,"empty":""}`,
+		},
+		{
+			Names: Renames{"first": "last", "last": "first"},
+			Omit:  true,
+			Want:  `{"upi":"38/XYZ","last":1,"first":10}`,
+		},
+		{
+			Names: Renames{"upi": "first"},
+			Fail:  true,
+		},
+		{
+			Names: Renames{"upi": "name", "last": "name"},
+			Fail:  true,
+		},
+	}
+	for i, d := range data {
+		bs, err := json.Marshal(withProfile(v, jsonProfile{Names: d.Names, OmitEmpty: d.Omit}))
+		if d.Fail {
+			if err == nil {
+				t.Errorf("%d: expected error, got %s", i, bs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if string(bs) != d.Want {
+			t.Errorf("%d: want %s, got %s", i, d.Want, bs)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/midbel/cli"
+)
+
+var lintCommand = &cli.Command{
+	Usage: "lint [-q] [-origin] [-pattern] [-upi-fields] [-seq64] [<filename|list.lst|->,...]",
+	Alias: []string{"validate"},
+	Short: "check that filenames follow the naming convention",
+	Run:   runLint,
+	Desc: `"lint" (validate) checks that the given filenames follow the naming convention
+of the Hadock archive (eg the filenames of a delivery manifest) and prints for
+each filename ok or the code of the rule it violates and why.
+
+The filenames are read from the arguments, from the files with the .lst
+extension given as argument (one filename per line) or from stdin when no
+argument or - is given. The files do not need to exist. "lint" fails if at
+least one filename violates a rule.
+
+The codes of the rules are: name (unsupported characters), pattern (does not
+match -pattern), fields (not enough fields), source (not hexadecimal), type
+(unknown type), origin (origin not accepted for the type), sequence (invalid
+sequence counter), time (invalid acquisition time) and parse (any other
+error).
+
+Options:
+
+  -q            only print the filenames that violate a rule
+  -origin T=LIST  accept the origins given by LIST for the files of type T
+  -pattern RE   parse the filenames with the given regular expression
+  -upi-fields F:L  build the UPI with the fields F to L (excluded) of the filename
+  -seq64        accept sequence counters encoded on 64 bits (default 32 bits)`,
+}
+
+func runLint(cmd *cli.Command, args []string) error {
+	opts := newParseOptions()
+	quiet := cmd.Flag.Bool("q", false, "quiet")
+	cmd.Flag.Var(&opts.Fields, "upi-fields", "upi fields")
+	cmd.Flag.BoolVar(&opts.Long, "seq64", false, "64 bits sequence")
+	pattern := cmd.Flag.String("pattern", "", "filename pattern")
+	cmd.Flag.Var(opts.Origins, "origin", "origins by type")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if opts.Pattern, err = compilePattern(*pattern); err != nil {
+		return err
+	}
+
+	var (
+		total, invalid int
+		w              = tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	)
+	lint := func(n string) {
+		total++
+		if code, err := lintFilename(n, opts); err != nil {
+			invalid++
+			fmt.Fprintf(w, "%s\t%s\t%s\n", n, code, err)
+		} else if !*quiet {
+			fmt.Fprintf(w, "%s\tok\t\n", n)
+		}
+	}
+	paths := cmd.Flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, p := range paths {
+		var err error
+		switch {
+		case p == "-":
+			err = lintList(os.Stdin, lint)
+		case filepath.Ext(p) == ".lst":
+			err = lintFile(p, lint)
+		default:
+			lint(p)
+		}
+		if err != nil {
+			w.Flush()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d/%d filename(s) do not follow the naming convention", invalid, total)
+	}
+	return nil
+}
+
+// lintFilename checks that the filename of p follows the naming convention and
+// gives the code of the rule it violates (see dropCode) if any.
+func lintFilename(p string, opts parseOptions) (string, error) {
+	_, err := parseName(p, "", 0, opts)
+	if err != nil {
+		return dropCode(err), err
+	}
+	return "", nil
+}
+
+func lintFile(p string, lint func(string)) error {
+	r, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return lintList(r, lint)
+}
+
+func lintList(r io.Reader, lint func(string)) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		n := strings.TrimSpace(s.Text())
+		if len(n) == 0 || strings.HasPrefix(n, "#") {
+			continue
+		}
+		lint(n)
+	}
+	return s.Err()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLintFilename(t *testing.T) {
+	data := []struct {
+		Name    string
+		Code    string
+		Pattern string
+		Fields  FieldRange
+	}{
+		{Name: "0038_XYZ_1_10_20190227_101010_00.dat"},
+		{Name: "/data/images/0038_XYZ_ABC_1_10_20190227_101010_00.dat"},
+		{Name: "0038_X:Z_1_10_20190227_101010_00.dat", Code: "name"},
+		{Name: "0038_XYZ_10.dat", Code: "fields"},
+		{Name: "zz38_XYZ_1_10_20190227_101010_00.dat", Code: "source"},
+		{Name: "0038_XYZ_9_10_20190227_101010_00.dat", Code: "type"},
+		{Name: "0038_XYZ_3_10_20190227_101010_00.dat", Code: "origin"},
+		{Name: "0038_XYZ_1_ten_20190227_101010_00.dat", Code: "sequence"},
+		{Name: "0038_XYZ_1_10_20191327_101010_00.dat", Code: "time"},
+		{
+			Name:    "0038_XYZ_1_10_20190227_101010_00.dat",
+			Code:    "pattern",
+			Pattern: `^(?P<source>[0-9a-f]+)-(?P<upi>\w+)-(?P<sequence>\d+)-(?P<time>\d{14})`,
+		},
+		{
+			Name:    "0038-XYZ-10-20190227101010.dat",
+			Pattern: `^(?P<source>[0-9a-f]+)-(?P<upi>\w+)-(?P<sequence>\d+)-(?P<time>\d{14})`,
+		},
+		{
+			Name:   "0038_XYZ_1_10_20190227_101010_00.dat",
+			Code:   "fields",
+			Fields: FieldRange{First: 1, Last: 10},
+		},
+	}
+	for _, d := range data {
+		re, err := compilePattern(d.Pattern)
+		if err != nil {
+			t.Fatalf("%s: %s", d.Pattern, err)
+		}
+		code, err := lintFilename(d.Name, parseOptions{Pattern: re, Fields: d.Fields})
+		if d.Code == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s (%s)", d.Name, err, code)
+			}
+			continue
+		}
+		if err == nil || code != d.Code {
+			t.Errorf("%s: want code %s, got %s (%v)", d.Name, d.Code, code, err)
+		}
+	}
+}
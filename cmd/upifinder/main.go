@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -41,8 +42,16 @@ Use {{.Name}} [command] -h for more information about its usage.
 `
 
 var commands = []*cli.Command{
+	auditCommand,
+	bothCommand,
 	checkCommand,
 	digestCommand,
+	explainCommand,
+	filesCommand,
+	lintCommand,
+	rangesCommand,
+	recoveredCommand,
+	statsCommand,
 	walkCommand,
 }
 
@@ -91,3 +100,12 @@ func Line(csv bool) *linewriter.Writer {
 	}
 	return linewriter.NewWriter(4096, options...)
 }
+
+// writeHeader writes the names of the columns as the first row of a csv output.
+func writeHeader(w io.Writer, cols []string) {
+	line := Line(true)
+	for _, c := range cols {
+		line.AppendString(c, 0, linewriter.AlignLeft)
+	}
+	io.Copy(w, line)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// AnySource is the source given to the files when the files of an UPI from
+// all sources are checked together (see check -merge-sources).
+const AnySource = "*"
+
+// sourceTimes gives by UPI (without source) and by source the acquisition
+// times of the files found.
+type sourceTimes map[string]map[string][]time.Time
+
+// mergeSources forwards a copy of the files of queue with AnySource as source
+// so that the files of an UPI are checked together whatever their source. The
+// acquisition times of the files are recorded by UPI and by source in the
+// returned sourceTimes that can be safely read once the returned channel is
+// closed.
+func mergeSources(queue <-chan *File) (<-chan *File, sourceTimes) {
+	q := make(chan *File)
+	ts := make(sourceTimes)
+	go func() {
+		defer close(q)
+		for f := range queue {
+			ss, ok := ts[f.Info]
+			if !ok {
+				ss = make(map[string][]time.Time)
+				ts[f.Info] = ss
+			}
+			ss[f.Source] = append(ss[f.Source], f.AcqTime)
+
+			c := *f
+			c.Source = AnySource
+			q <- &c
+		}
+	}()
+	return q, ts
+}
+
+// attributeGaps sets the sources of the gaps of gs found by checking the files
+// of all sources together. A gap is attributed to the sources of its UPI that
+// have no file acquired between the files around the gap (included), ie the
+// sources that were silent during the gap. If every source has files during the
+// gap, the gap is attributed to all of them.
+func attributeGaps(gs []*Gap, ts sourceTimes) {
+	for _, ss := range ts {
+		for _, vs := range ss {
+			sort.Slice(vs, func(i, j int) bool { return vs[i].Before(vs[j]) })
+		}
+	}
+	for _, g := range gs {
+		if g.absent {
+			continue
+		}
+		ss := ts[strings.TrimPrefix(g.UPI, AnySource+"/")]
+
+		var all []string
+		for s, vs := range ss {
+			all = append(all, s)
+			ix := sort.Search(len(vs), func(i int) bool {
+				return !vs[i].Before(g.Starts)
+			})
+			if ix >= len(vs) || vs[ix].After(g.Ends) {
+				g.Sources = append(g.Sources, s)
+			}
+		}
+		if len(g.Sources) == 0 {
+			g.Sources = all
+		}
+		sort.Strings(g.Sources)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeSources(t *testing.T) {
+	// source 38 gives the odd counters and source 39 the even ones. 39 is
+	// silent from 20 to 30.
+	var fs []*File
+	for i := uint64(1); i <= 40; i++ {
+		f := testFile("XYZ", i, false)
+		if i%2 == 0 {
+			if i >= 20 && i <= 30 {
+				continue
+			}
+			f.Source = "39"
+		}
+		fs = append(fs, f)
+	}
+	queue, times := mergeSources(feedFiles(fs))
+	gs := checkFiles(queue, 0, false, 0, byUPI)
+	attributeGaps(gs, times)
+	sortGaps(gs, false)
+
+	want := []gapBounds{{19, 21}, {21, 23}, {23, 25}, {25, 27}, {27, 29}, {29, 31}}
+	compareGaps(t, gs, want)
+	for _, g := range gs {
+		if g.UPI != AnySource+"/XYZ" {
+			t.Errorf("%d-%d: want UPI %s/XYZ, got %s", g.Before, g.After, AnySource, g.UPI)
+		}
+		if s := strings.Join(g.Sources, ","); s != "39" {
+			t.Errorf("%d-%d: want gap attributed to 39, got %s", g.Before, g.After, s)
+		}
+	}
+
+	// checked by source, the counters of each source are not contiguous.
+	if gs := checkFiles(feedFiles(fs), 0, false, 0, bySource); len(gs) == 0 {
+		t.Errorf("by source: expected gaps")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"strings"
+)
+
+// Metadata holds the fields read from the xml file written by hadock next to a
+// data file.
+type Metadata struct {
+	Mode    string `json:"mode,omitempty" xml:"mode"`
+	Quality string `json:"quality,omitempty" xml:"quality"`
+}
+
+// readMetadata reads the companion xml of the data file p. The companion is
+// either p followed by .xml or p with its extension replaced by .xml. A nil
+// Metadata is returned when none of them exists.
+func readMetadata(p string) (*Metadata, error) {
+	for _, x := range companions(p) {
+		r, err := os.Open(x)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		var m Metadata
+		if err := xml.NewDecoder(r).Decode(&m); err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+func companions(p string) []string {
+	ps := []string{p + ".xml"}
+	if i := strings.LastIndexByte(p, '.'); i > strings.LastIndexByte(p, os.PathSeparator) {
+		ps = append(ps, p[:i]+".xml")
+	}
+	return ps
+}
+
+// joinMetadata attaches to the files of queue the metadata of their companion
+// xml. Files without companion (eg found in a tar or lst file) are forwarded
+// without metadata.
+func joinMetadata(queue <-chan *File) <-chan *File {
+	q := make(chan *File)
+	go func() {
+		defer close(q)
+		for f := range queue {
+			if m, err := readMetadata(f.Path); err == nil {
+				f.Meta = m
+			}
+			q <- f
+		}
+	}()
+	return q
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testSidecar = `<?xml version="1.0"?>
+<product>
+	<mode>realtime</mode>
+	<quality>good</quality>
+</product>`
+
+func TestReadMetadata(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// companion with .xml appended to the name of the file.
+		"a.dat":     "",
+		"a.dat.xml": testSidecar,
+		// companion with the extension replaced.
+		"b.dat": "",
+		"b.xml": testSidecar,
+		// without companion.
+		"c.dat": "",
+		// invalid companion.
+		"d.dat":     "",
+		"d.dat.xml": "<product><mode>",
+	}
+	for n, c := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := Metadata{Mode: "realtime", Quality: "good"}
+	for _, n := range []string{"a.dat", "b.dat"} {
+		m, err := readMetadata(filepath.Join(dir, n))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", n, err)
+			continue
+		}
+		if m == nil || *m != want {
+			t.Errorf("%s: want metadata %+v, got %+v", n, want, m)
+		}
+	}
+	if m, err := readMetadata(filepath.Join(dir, "c.dat")); m != nil || err != nil {
+		t.Errorf("c.dat: want no metadata, got %+v (%v)", m, err)
+	}
+	if _, err := readMetadata(filepath.Join(dir, "d.dat")); err == nil {
+		t.Errorf("d.dat: want error, got none")
+	}
+
+	var fs []*File
+	for _, n := range []string{"a.dat", "c.dat", "d.dat"} {
+		fs = append(fs, &File{Path: filepath.Join(dir, n)})
+	}
+	var i int
+	for f := range joinMetadata(feedFiles(fs)) {
+		if i == 0 && (f.Meta == nil || *f.Meta != want) {
+			t.Errorf("%s: want metadata %+v, got %+v", f.Path, want, f.Meta)
+		}
+		if i > 0 && f.Meta != nil {
+			t.Errorf("%s: want no metadata, got %+v", f.Path, f.Meta)
+		}
+		i++
+	}
+	if i != len(fs) {
+		t.Errorf("want %d files, got %d", len(fs), i)
+	}
+}
+
+func TestCompanions(t *testing.T) {
+	data := []struct {
+		Path string
+		Want []string
+	}{
+		{Path: "dir/a.dat", Want: []string{"dir/a.dat.xml", "dir/a.xml"}},
+		{Path: "dir/a", Want: []string{"dir/a.xml"}},
+		{Path: "dir.d/a", Want: []string{"dir.d/a.xml"}},
+	}
+	for _, d := range data {
+		got := companions(filepath.FromSlash(d.Path))
+		if len(got) != len(d.Want) {
+			t.Errorf("%s: want %v, got %v", d.Path, d.Want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != filepath.FromSlash(d.Want[i]) {
+				t.Errorf("%s: companion %d: want %s, got %s", d.Path, i, d.Want[i], got[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// logFile is a file opened in append mode that is reopened when the process
+// receives a SIGHUP, eg after the file has been rotated.
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	sig  chan os.Signal
+}
+
+func openLog(p string) (*logFile, error) {
+	f := logFile{path: p}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	f.sig = make(chan os.Signal, 1)
+	signal.Notify(f.sig, syscall.SIGHUP)
+	go func() {
+		for range f.sig {
+			f.Reopen()
+		}
+	}()
+	return &f, nil
+}
+
+func (f *logFile) Write(bs []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Write(bs)
+}
+
+func (f *logFile) Reopen() error {
+	w, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = w
+	return nil
+}
+
+func (f *logFile) Close() error {
+	signal.Stop(f.sig)
+	close(f.sig)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
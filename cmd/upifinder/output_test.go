@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenLog(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "upifinder.log")
+	if err := ioutil.WriteFile(p, []byte("previous run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openLog(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer f.Close()
+
+	// the results are written both to stdout and to the log file.
+	var stdout bytes.Buffer
+	w := io.MultiWriter(&stdout, f)
+	reportWalkResults(w, []*Coze{testCoze("XYZ", 1, 2, 4)}, walkOptions{CSV: true, Now: testEpoch})
+	buf, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "previous run\n" + stdout.String(); string(buf) != want {
+		t.Errorf("log file: want\n%s\ngot\n%s", want, buf)
+	}
+
+	// the file is rotated then reopened on SIGHUP.
+	f.mu.Lock()
+	old := f.file
+	f.mu.Unlock()
+	rotated := filepath.Join(dir, "upifinder.log.1")
+	if err := os.Rename(p, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		f.mu.Lock()
+		reopened := f.file != old
+		f.mu.Unlock()
+		if reopened {
+			break
+		}
+		if i >= 100 {
+			t.Fatalf("log file not reopened after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	io.WriteString(w, "after rotation\n")
+	if buf, _ := ioutil.ReadFile(p); string(buf) != "after rotation\n" {
+		t.Errorf("reopened file: unexpected content %q", buf)
+	}
+	if buf, _ := ioutil.ReadFile(rotated); bytes.Contains(buf, []byte("after rotation")) {
+		t.Errorf("rotated file written after SIGHUP")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var patternGroups = []string{"source", "upi", "sequence", "time"}
+
+// compilePattern compiles the pattern that replaces the default parsing of the
+// filenames (fields separated by underscores). It should be a regular
+// expression with the following named groups:
+//
+//   - source: source of the file (hexadecimal, required)
+//   - upi: UPI of the file (required)
+//   - sequence: sequence counter of the file (required)
+//   - time: acquisition time of the file as YYYYmmddHHMMSS or HHMMSS if the
+//     date group is also given (required)
+//   - date: acquisition date of the file as YYYYmmdd (optional)
+//   - type: type of the file used to select the accepted origins (optional)
+//   - delta: number of minutes between acquisition and reception (optional)
+//
+// No pattern is given when p is empty.
+func compilePattern(p string) (*regexp.Regexp, error) {
+	if p == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	names := re.SubexpNames()
+	for _, g := range patternGroups {
+		var found bool
+		for _, n := range names {
+			if found = n == g; found {
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("pattern: missing group %s", g)
+		}
+	}
+	return re, nil
+}
+
+func parsePattern(p, upi string, i int64, opts parseOptions) (*File, error) {
+	ms := opts.Pattern.FindStringSubmatch(filepath.Base(p))
+	if ms == nil {
+		return nil, ErrPattern
+	}
+	vs := make(map[string]string)
+	for j, n := range opts.Pattern.SubexpNames() {
+		if n != "" && ms[j] != "" {
+			vs[n] = ms[j]
+		}
+	}
+
+	f := File{
+		Path:   p,
+		Source: strings.TrimLeft(vs["source"], "0"),
+		Size:   i,
+		Stored: i,
+		typ:    vs["type"],
+	}
+	if len(upi) == 0 {
+		f.Info = vs["upi"]
+	} else {
+		f.Info = upi
+	}
+	// a file discarded because of its origin is still parsed to give all its
+	// information to the caller but the origin takes precedence over other
+	// errors.
+	discard := f.checkOrigin(opts.origins())
+	if n, err := parseSequence(vs["sequence"], opts.Long); err == nil {
+		f.Sequence = n
+	} else {
+		return &f, firstError(discard, fmt.Errorf("%w: %s", ErrSequence, err))
+	}
+	if t, err := time.Parse("20060102150405", vs["date"]+vs["time"]); err == nil {
+		f.AcqTime = t
+		f.RecTime = t
+	} else {
+		return &f, firstError(discard, fmt.Errorf("%w: %s", ErrTime, err))
+	}
+	if d, ok := vs["delta"]; ok {
+		d, _ := strconv.ParseInt(strings.TrimLeft(d, "0"), 10, 64)
+		f.RecTime = f.AcqTime.Add(time.Duration(d) * time.Minute)
+	}
+	return &f, discard
+}
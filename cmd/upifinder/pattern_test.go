@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompilePattern(t *testing.T) {
+	data := []struct {
+		Pattern string
+		Err     bool
+	}{
+		{Pattern: `^(?P<source>[0-9a-f]+)-(?P<upi>\w+)-(?P<sequence>\d+)-(?P<time>\d{14})`},
+		{Pattern: `^(?P<source>[0-9a-f]+)-(?P<upi>\w+)-(?P<sequence>\d+)-(?P<date>\d{8})-(?P<time>\d{6})`},
+		{Pattern: `^(?P<source>[0-9a-f]+)-(?P<upi>\w+)-(?P<sequence>\d+`, Err: true},
+		{Pattern: `^(?P<source>[0-9a-f]+)-(?P<upi>\w+)-(?P<time>\d{14})`, Err: true},
+		{Pattern: `^([0-9a-f]+)-(\w+)-(\d+)-(\d{14})`, Err: true},
+	}
+	for _, d := range data {
+		re, err := compilePattern(d.Pattern)
+		if d.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", d.Pattern)
+			}
+			continue
+		}
+		if err != nil || re == nil {
+			t.Errorf("%s: unexpected error: %v", d.Pattern, err)
+		}
+	}
+	if re, err := compilePattern(""); re != nil || err != nil {
+		t.Errorf("empty pattern: want no pattern, got %v (%v)", re, err)
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	re, err := compilePattern(`^(?P<source>[0-9a-f]+)-(?P<type>\d)-(?P<upi>[A-Z]+)-(?P<sequence>\w+)-(?P<date>\d{8})-(?P<time>\d{6})(-(?P<delta>\d+))?\.dat$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := parseOptions{Pattern: re}
+	acq := time.Date(2019, 2, 27, 10, 10, 10, 0, time.UTC)
+	data := []struct {
+		Name  string
+		UPI   string
+		Delta time.Duration
+		Err   error
+	}{
+		{Name: "0038-1-XYZ-10-20190227-101010.dat", UPI: "XYZ"},
+		{Name: "/data/0038-1-XYZ-10-20190227-101010-15.dat", UPI: "XYZ", Delta: 15 * time.Minute},
+		{Name: "0038_1_XYZ_10_20190227_101010.dat", Err: ErrPattern},
+		{Name: "0038-3-XYZ-10-20190227-101010.dat", Err: ErrOrigin},
+		{Name: "0038-1-XYZ-ten-20190227-101010.dat", Err: ErrSequence},
+		{Name: "0038-1-XYZ-10-20191327-101010.dat", Err: ErrTime},
+	}
+	for _, d := range data {
+		f, err := parseName(d.Name, "", 100, opts)
+		if d.Err != nil {
+			if !errors.Is(err, d.Err) {
+				t.Errorf("%s: want %s, got %v", d.Name, d.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if f.Source != "38" || f.Info != d.UPI || f.Sequence != 10 || f.Size != 100 || f.typ != "1" {
+			t.Errorf("%s: unexpected file %+v", d.Name, *f)
+		}
+		if !f.AcqTime.Equal(acq) || f.RecTime.Sub(f.AcqTime) != d.Delta {
+			t.Errorf("%s: want acqtime %s (delta %s), got %s (delta %s)", d.Name, acq, d.Delta, f.AcqTime, f.RecTime.Sub(f.AcqTime))
+		}
+	}
+	// the UPI given with -u replaces the UPI of the filename.
+	if f, err := parseName("0038-1-XYZ-10-20190227-101010.dat", "ABC", 0, opts); err != nil || f.Info != "ABC" {
+		t.Errorf("upi: want ABC, got %v (%v)", f, err)
+	}
+}
@@ -1,8 +1,17 @@
 package main
 
 import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
+
+	"github.com/midbel/linewriter"
 )
 
 func Transform(upi string) string {
@@ -26,3 +35,131 @@ func Keep(n string) bool {
 	}
 	return true
 }
+
+// fileName gives a name suitable to be used as a filename from upi.
+func fileName(upi string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && Keep(string(r)) && r != '.' {
+			return r
+		}
+		return '_'
+	}, Transform(upi))
+}
+
+// fileNames gives by UPI of upis a filename (see fileName) that is not used by
+// another UPI. The UPI whose names collide once sanitized get a suffix made of
+// the hash of the UPI.
+func fileNames(upis []string) map[string]string {
+	var (
+		ns = make(map[string]string)
+		us = make(map[string][]string)
+	)
+	for _, u := range upis {
+		n := fileName(u)
+		if _, ok := ns[u]; ok {
+			continue
+		}
+		ns[u] = n
+		us[n] = append(us[n], u)
+	}
+	names := make([]string, 0, len(us))
+	for n := range us {
+		names = append(names, n)
+	}
+	// the collisions are reported in the order of the filenames.
+	sort.Strings(names)
+	for _, n := range names {
+		vs := us[n]
+		if len(vs) <= 1 {
+			continue
+		}
+		for _, u := range vs {
+			h := fnv.New32a()
+			io.WriteString(h, u)
+			ns[u] = fmt.Sprintf("%s-%08x", n, h.Sum32())
+		}
+		fmt.Fprintf(os.Stderr, "%s: filename used by %d UPI (%s), hash added\n", n, len(vs), strings.Join(vs, ", "))
+	}
+	return ns
+}
+
+// loadZone gives the timezone named n used to print the acquisition times. The
+// times are always parsed and compared in UTC. UTC is given when n is empty.
+func loadZone(n string) (*time.Location, error) {
+	if n == "" {
+		return time.UTC, nil
+	}
+	z, err := time.LoadLocation(n)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s: %w", n, err)
+	}
+	return z, nil
+}
+
+// Local gives t in the timezone z selected to print the times (UTC when z is
+// nil).
+func Local(t time.Time, z *time.Location) time.Time {
+	if z == nil {
+		z = time.UTC
+	}
+	return t.In(z)
+}
+
+// numberFormat gives the decimal separator and the thousands separator used to
+// write the sizes and the ratios as csv (see -decimal and -thousands). The
+// numbers are written as is with the zero value.
+type numberFormat struct {
+	Decimal   string
+	Thousands string
+}
+
+func newNumberFormat(decimal, thousands string) (numberFormat, error) {
+	if decimal == "" {
+		decimal = "."
+	}
+	if decimal == thousands {
+		return numberFormat{}, fmt.Errorf("decimal and thousands separators can not be the same")
+	}
+	return numberFormat{Decimal: decimal, Thousands: thousands}, nil
+}
+
+func (n numberFormat) appendUint(line *linewriter.Writer, v uint64) {
+	if n.Thousands == "" {
+		line.AppendUint(v, 10, linewriter.AlignRight)
+		return
+	}
+	line.AppendString(n.groupThousands(strconv.FormatUint(v, 10)), 10, linewriter.AlignRight)
+}
+
+func (n numberFormat) appendFloat(line *linewriter.Writer, v float64) {
+	decimal := n.Decimal
+	if decimal == "" {
+		decimal = "."
+	}
+	if decimal == "." && n.Thousands == "" {
+		line.AppendFloat(v, 10, 2, linewriter.AlignRight)
+		return
+	}
+	str := strconv.FormatFloat(v, 'f', 2, 64)
+	ix := strings.Index(str, ".")
+	line.AppendString(n.groupThousands(str[:ix])+decimal+str[ix+1:], 10, linewriter.AlignRight)
+}
+
+// groupThousands inserts the thousands separator in the digits of str.
+func (n numberFormat) groupThousands(str string) string {
+	if n.Thousands == "" {
+		return str
+	}
+	var sign string
+	if strings.HasPrefix(str, "-") {
+		sign, str = "-", str[1:]
+	}
+	var b strings.Builder
+	for i, r := range str {
+		if i > 0 && (len(str)-i)%3 == 0 {
+			b.WriteString(n.Thousands)
+		}
+		b.WriteRune(r)
+	}
+	return sign + b.String()
+}
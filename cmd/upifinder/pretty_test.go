@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadZone(t *testing.T) {
+	data := []struct {
+		Zone string
+		Want string
+		Err  bool
+	}{
+		{Want: "2019-02-27T10:00:00Z"},
+		{Zone: "UTC", Want: "2019-02-27T10:00:00Z"},
+		{Zone: "Europe/Brussels", Want: "2019-02-27T11:00:00+01:00"},
+		{Zone: "America/New_York", Want: "2019-02-27T05:00:00-05:00"},
+		{Zone: "Europe/Nowhere", Err: true},
+	}
+	for _, d := range data {
+		z, err := loadZone(d.Zone)
+		if d.Err {
+			if err == nil {
+				t.Errorf("%s: want error, got none", d.Zone)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Zone, err)
+			continue
+		}
+		w := Local(testEpoch, z)
+		if got := w.Format(time.RFC3339); got != d.Want {
+			t.Errorf("%s: want %s, got %s", d.Zone, d.Want, got)
+		}
+		if !w.Equal(testEpoch) {
+			t.Errorf("%s: time moved: %s", d.Zone, w)
+		}
+	}
+	if got := Local(testEpoch.In(time.FixedZone("CET", 3600)), nil); got.Location() != time.UTC {
+		t.Errorf("no zone: want UTC, got %s", got.Location())
+	}
+
+	// the times of the gaps are printed in the zone selected.
+	z, _ := loadZone("Europe/Brussels")
+	gs := testGaps("XYZ", 1, 3)
+	var buf bytes.Buffer
+	reportCheckResults(&buf, gs, checkOptions{CSV: true, Zone: z})
+	vs := strings.Split(strings.TrimSpace(buf.String()), ",")
+	if want := Local(gs[0].Starts, z).Format(time.RFC3339); strings.TrimSpace(vs[1]) != want || !strings.HasSuffix(want, "+01:00") {
+		t.Errorf("want start %s, got %s", want, vs[1])
+	}
+}
+
+func TestNumberFormat(t *testing.T) {
+	data := []struct {
+		Decimal   string
+		Thousands string
+		Uint      uint64
+		Float     float64
+		WantUint  string
+		WantFloat string
+	}{
+		{Uint: 1234567, Float: 1234.5, WantUint: "1234567", WantFloat: "1234.50"},
+		{Decimal: ",", Uint: 1234567, Float: 1234.5, WantUint: "1234567", WantFloat: "1234,50"},
+		{Thousands: " ", Uint: 1234567, Float: 1234.5, WantUint: "1 234 567", WantFloat: "1 234.50"},
+		{Decimal: ",", Thousands: ".", Uint: 1234567, Float: 1234567.891, WantUint: "1.234.567", WantFloat: "1.234.567,89"},
+		{Decimal: ",", Thousands: ".", Uint: 999, Float: 999.994, WantUint: "999", WantFloat: "999,99"},
+		{Decimal: ",", Thousands: ".", Uint: 0, Float: 0, WantUint: "0", WantFloat: "0,00"},
+		// the sign is not grouped with the digits.
+		{Decimal: ",", Thousands: ".", Uint: 100000, Float: -123456.5, WantUint: "100.000", WantFloat: "-123.456,50"},
+		{Decimal: ",", Thousands: ".", Uint: 1000, Float: -999.5, WantUint: "1.000", WantFloat: "-999,50"},
+	}
+	for _, d := range data {
+		n, err := newNumberFormat(d.Decimal, d.Thousands)
+		if err != nil {
+			t.Errorf("%q/%q: unexpected error: %s", d.Decimal, d.Thousands, err)
+			continue
+		}
+		var buf bytes.Buffer
+		line := Line(true)
+		n.appendUint(line, d.Uint)
+		buf.ReadFrom(line)
+		if got := strings.TrimSpace(buf.String()); got != d.WantUint {
+			t.Errorf("%q/%q: want %s, got %s", d.Decimal, d.Thousands, d.WantUint, got)
+		}
+		buf.Reset()
+		n.appendFloat(line, d.Float)
+		buf.ReadFrom(line)
+		if got := strings.TrimSpace(buf.String()); got != d.WantFloat {
+			t.Errorf("%q/%q: want %s, got %s", d.Decimal, d.Thousands, d.WantFloat, got)
+		}
+	}
+
+	// the zero value writes the numbers as is.
+	var n numberFormat
+	if got := n.groupThousands("-1234567"); got != "-1234567" {
+		t.Errorf("zero value: want -1234567, got %s", got)
+	}
+	if n, _ := newNumberFormat("", ""); n.Decimal != "." {
+		t.Errorf("want default decimal separator, got %q", n.Decimal)
+	}
+	for _, s := range [][2]string{{",", ","}, {"", "."}, {".", "."}} {
+		if _, err := newNumberFormat(s[0], s[1]); err == nil {
+			t.Errorf("%q/%q: want error, got none", s[0], s[1])
+		}
+	}
+}
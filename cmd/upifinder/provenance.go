@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kinds of location where a file can be found.
+const (
+	ProvLoose = "loose"
+	ProvList  = "lst"
+	ProvTar   = "tar"
+	ProvZip   = "zip"
+)
+
+func checkProvenance(p string) error {
+	switch p {
+	case "", ProvLoose, ProvList, ProvTar, ProvZip:
+		return nil
+	default:
+		return fmt.Errorf("unsupported provenance %s", p)
+	}
+}
+
+// preferFiles looks for the files with the same UPI and sequence counter found
+// in different kinds of location (eg in a lst file and on the filesystem).
+// Each conflict is written to w. If the files found in the location prefer
+// are in conflict, the others are discarded. Without prefer, the copy in a zip
+// archive of a file found on the filesystem is discarded like walkFiles does.
+//
+// preferFiles has to read all the files of queue before sending the first one:
+// every file found is kept in memory until the walk is done.
+func preferFiles(queue <-chan *File, prefer string, w io.Writer) <-chan *File {
+	type key struct {
+		UPI      string
+		Sequence uint64
+	}
+	q := make(chan *File)
+	go func() {
+		defer close(q)
+
+		var (
+			keys []key
+			fs   = make(map[key][]*File)
+		)
+		for f := range queue {
+			k := key{UPI: f.String(), Sequence: f.Sequence}
+			if _, ok := fs[k]; !ok {
+				keys = append(keys, k)
+			}
+			fs[k] = append(fs[k], f)
+		}
+		for _, k := range keys {
+			vs := fs[k]
+			if len(vs) > 1 {
+				vs = resolveProvenance(fmt.Sprintf("%s/%d", k.UPI, k.Sequence), vs, prefer, w)
+			}
+			for _, f := range vs {
+				q <- f
+			}
+		}
+	}()
+	return q
+}
+
+func resolveProvenance(k string, fs []*File, prefer string, w io.Writer) []*File {
+	var (
+		ps   []string
+		seen = make(map[string]bool)
+	)
+	for _, f := range fs {
+		if !seen[f.Provenance] {
+			seen[f.Provenance] = true
+			ps = append(ps, f.Provenance)
+		}
+	}
+	if len(ps) <= 1 {
+		return fs
+	}
+	fmt.Fprintf(w, "%s: found in %s\n", k, strings.Join(ps, ", "))
+	if prefer == "" {
+		if !seen[ProvLoose] || !seen[ProvZip] {
+			return fs
+		}
+		return withoutProvenance(fs, ProvZip)
+	}
+	var keep []*File
+	for _, f := range fs {
+		if f.Provenance == prefer {
+			keep = append(keep, f)
+		}
+	}
+	if len(keep) == 0 {
+		return fs
+	}
+	return keep
+}
+
+func withoutProvenance(fs []*File, p string) []*File {
+	var vs []*File
+	for _, f := range fs {
+		if f.Provenance != p {
+			vs = append(vs, f)
+		}
+	}
+	return vs
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+var rangesCommand = &cli.Command{
+	Usage: "ranges [-d] [-s] [-e] [-year] [-doy] [-u] [-c] [-header] [-seq64] <archive,...>",
+	Short: "provide the ranges of sequence counters found and missing by UPI",
+	Run:   runRanges,
+	Desc: `"ranges" traverse the Hadock archive and print one row for each range of
+sequence counters found and for each range of missing sequence counters of
+each UPI, from the first to the last sequence counter.
+
+A range of missing sequence counters is given by the sequence counters of the
+files around it (like the gaps of "check-upi").
+
+The period of time is selected with the same rules as the "walk" command.
+
+Options:
+
+  -u UPI     only count files for the given UPI
+  -s START   only count files created after START
+  -e END     only count files created before END
+  -d DAYS    only count files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only count files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
+  -c         print the results as csv
+  -header    print the names of the columns as first row of the csv (default
+             true, disabled with -header=false)
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)`,
+}
+
+func runRanges(cmd *cli.Command, args []string) error {
+	var start, end When
+	cmd.Flag.Var(&start, "s", "start")
+	cmd.Flag.Var(&end, "e", "end")
+	upi := cmd.Flag.String("u", "", "upi")
+	period := cmd.Flag.Int("d", 0, "period")
+	year := cmd.Flag.Int("year", 0, "year")
+	var days DayList
+	cmd.Flag.Var(&days, "doy", "days of year")
+	csv := cmd.Flag.Bool("c", false, "csv")
+	header := cmd.Flag.Bool("header", true, "csv header")
+	long := cmd.Flag.Bool("seq64", false, "64 bits sequence")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+
+	if cmd.Flag.NArg() == 0 {
+		cmd.Help()
+	}
+
+	paths, err := selectPaths(cmd.Flag.Args(), *period, start.Time, end.Time, *year, days)
+	if err != nil {
+		return err
+	}
+	queue := walkFiles(paths, scanOptions{
+		UPI:      *upi,
+		Parallel: 8,
+		Buffer:   DefaultBuffer,
+		Parse:    parseOptions{Long: *long},
+	})
+	rs := countFiles(queue, runtime.NumCPU(), countOptions{})
+	if len(rs) == 0 {
+		return nil
+	}
+	if *csv && *header {
+		writeHeader(os.Stdout, []string{"upi", "index", "first", "last", "total", "gap"})
+	}
+	reportRanges(os.Stdout, sortCozes(rs, false), *csv)
+	return nil
+}
+
+// reportRanges writes the ranges of sequence counters found (Ranges) and the
+// ranges missing between them (MissingRanges) of each Coze in the order of
+// their sequence counters.
+func reportRanges(w io.Writer, cs []*Coze, csv bool) {
+	line := Line(csv)
+	for _, c := range cs {
+		var (
+			rs = c.Ranges()
+			ms = c.MissingRanges()
+			ix uint64
+		)
+		for i, r := range rs {
+			appendRange(line, c.UPI, ix, r, false, csv)
+			io.Copy(w, line)
+			ix++
+			if i < len(ms) {
+				appendRange(line, c.UPI, ix, ms[i], true, csv)
+				io.Copy(w, line)
+				ix++
+			}
+		}
+	}
+}
+
+func appendRange(line *linewriter.Writer, upi string, ix uint64, r *Range, gap, csv bool) {
+	// the bounds of a missing range are the last and first sequence counters
+	// found around it.
+	total := r.Last - r.First + 1
+	if gap {
+		total -= 2
+	}
+	line.AppendString(Transform(upi), 24, linewriter.AlignLeft)
+	line.AppendUint(ix, 6, linewriter.AlignRight)
+	line.AppendUint(r.First, 10, linewriter.AlignRight)
+	line.AppendUint(r.Last, 10, linewriter.AlignRight)
+	line.AppendUint(total, 10, linewriter.AlignRight)
+	if csv {
+		if gap {
+			line.AppendString("true", 5, linewriter.AlignLeft)
+		} else {
+			line.AppendString("false", 5, linewriter.AlignLeft)
+		}
+	} else {
+		if gap {
+			line.AppendString("missing", 8, linewriter.AlignLeft)
+		} else {
+			line.AppendString("found", 8, linewriter.AlignLeft)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReportRanges(t *testing.T) {
+	data := []*Coze{
+		testCoze("AAA", 1, 2, 3),
+		testCoze("BBB", 1, 2, 5, 6, 9),
+		testCoze("CCC", 10, 4, 5, 1),
+		testCoze("DDD", 1, -2, 3, 7),
+	}
+	for _, c := range data {
+		var (
+			buf  bytes.Buffer
+			want = len(c.Ranges()) + len(c.MissingRanges())
+		)
+		reportRanges(&buf, []*Coze{c}, true)
+		rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(rows) != want {
+			t.Errorf("%s: want %d rows, got %d", c.UPI, want, len(rows))
+			continue
+		}
+		// found and missing ranges alternate, starting with a found range.
+		for i, r := range rows {
+			gap := strings.HasSuffix(r, "true")
+			if gap != (i%2 == 1) {
+				t.Errorf("%s: row %d: unexpected row %q", c.UPI, i, r)
+			}
+		}
+	}
+}
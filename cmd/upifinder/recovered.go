@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+var recoveredCommand = &cli.Command{
+	Usage: "recovered [-b] [-d] [-s] [-e] [-year] [-doy] [-u] [-c] [-k] [-seq64] <archive,...>",
+	Alias: []string{"replay"},
+	Short: "provide the gaps refilled by a later playback/replay",
+	Run:   runRecovered,
+	Desc: `"recovered" (replay) traverse the Hadock archive to find the gap(s) of files that
+existed when the files are taken in the order they are found in the archive but
+that were later refilled by a playback or a replay.
+
+The period of time is selected with the same rules as the "check-upi" command.
+
+Options:
+
+  -b BY      check gaps by upi or by source (default by upi)
+  -u UPI     only count files for the given UPI
+  -s START   only count files created after START
+  -e END     only count files created before END
+  -d DAYS    only count files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only count files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
+  -c         print the results as csv
+  -k         keep invalid files in the count of gaps
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)`,
+}
+
+func runRecovered(cmd *cli.Command, args []string) error {
+	var start, end When
+	cmd.Flag.Var(&start, "s", "start")
+	cmd.Flag.Var(&end, "e", "end")
+	by := cmd.Flag.String("b", "", "by")
+	upi := cmd.Flag.String("u", "", "upi")
+	period := cmd.Flag.Int("d", 0, "period")
+	year := cmd.Flag.Int("year", 0, "year")
+	var days DayList
+	cmd.Flag.Var(&days, "doy", "days of year")
+	csv := cmd.Flag.Bool("c", false, "csv")
+	keep := cmd.Flag.Bool("k", false, "keep invalid files")
+	long := cmd.Flag.Bool("seq64", false, "64 bits sequence")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+
+	if cmd.Flag.NArg() == 0 {
+		cmd.Help()
+	}
+
+	paths, err := selectPaths(cmd.Flag.Args(), *period, start.Time, end.Time, *year, days)
+	if err != nil {
+		return err
+	}
+	var byf ByFunc
+	switch strings.ToLower(*by) {
+	case "upi", "":
+		byf = byUPI
+	case "source":
+		byf = bySource
+	default:
+		return fmt.Errorf("unsupported %s", *by)
+	}
+	var files []*File
+	for f := range walkFiles(paths, scanOptions{
+		UPI:      *upi,
+		Parallel: 1,
+		Buffer:   DefaultBuffer,
+		Parse:    parseOptions{Long: *long},
+	}) {
+		files = append(files, f)
+	}
+	raw := rawGaps(files, *keep, byf)
+	if len(raw) == 0 {
+		return nil
+	}
+	rs := recoveredGaps(raw, files, *keep)
+	if len(rs) > 0 {
+		reportRecoveredResults(rs, *csv)
+	}
+	return nil
+}
+
+// Recovered is a gap found when the files are taken in the order they appear
+// in the archive with the number of its files refilled later.
+type Recovered struct {
+	*Gap
+	Refilled uint64
+}
+
+func reportRecoveredResults(rs []*Recovered, csv bool) {
+	line := Line(csv)
+	for _, r := range rs {
+		line.AppendString(Transform(r.UPI), 24, linewriter.AlignLeft)
+		line.AppendTime(r.Starts, time.RFC3339, linewriter.AlignRight)
+		line.AppendTime(r.Ends, time.RFC3339, linewriter.AlignRight)
+		line.AppendUint(r.Before, 10, linewriter.AlignRight)
+		line.AppendUint(r.After, 10, linewriter.AlignRight)
+		line.AppendUint(r.Count(), 10, linewriter.AlignRight)
+		line.AppendUint(r.Refilled, 10, linewriter.AlignRight)
+
+		io.Copy(os.Stdout, line)
+	}
+}
+
+// rawGaps gives the gaps between the files of fs (by key) in the order they
+// are found without taking into account the files that refill them later.
+func rawGaps(fs []*File, keep bool, by ByFunc) []*Gap {
+	var (
+		gs []*Gap
+		cs = make(map[string]*File)
+	)
+	for _, f := range fs {
+		if !f.Valid() && !keep {
+			continue
+		}
+		n := by(f)
+		p, ok := cs[n]
+		if ok && f.Sequence <= p.Sequence {
+			continue
+		}
+		if ok {
+			if g := f.Compare(p); g != nil && g.Count() > 0 {
+				gs = append(gs, g)
+			}
+		}
+		cs[n] = f
+	}
+	return gs
+}
+
+// recoveredGaps gives the raw gaps whose files are found later in fs (eg by a
+// playback or a replay), completely or partially. The files of a raw gap can
+// only be found after it: the files found before have a lower sequence counter
+// than the start of the gap (see rawGaps).
+func recoveredGaps(raw []*Gap, fs []*File, keep bool) []*Recovered {
+	seqs := make(map[string][]uint64)
+	for _, f := range fs {
+		if !f.Valid() && !keep {
+			continue
+		}
+		seqs[f.String()] = append(seqs[f.String()], f.Sequence)
+	}
+	for k, vs := range seqs {
+		seqs[k] = uniqSequences(vs)
+	}
+
+	var rs []*Recovered
+	for _, g := range raw {
+		vs := seqs[g.UPI]
+		first := sort.Search(len(vs), func(i int) bool { return vs[i] > g.Before })
+		last := sort.Search(len(vs), func(i int) bool { return vs[i] >= g.After })
+		if n := last - first; n > 0 {
+			rs = append(rs, &Recovered{Gap: g, Refilled: uint64(n)})
+		}
+	}
+	sortRecovered(rs)
+	return rs
+}
+
+// uniqSequences sorts vs and removes the sequence counters found more than
+// once.
+func uniqSequences(vs []uint64) []uint64 {
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+	var n int
+	for i := range vs {
+		if i > 0 && vs[i] == vs[n-1] {
+			continue
+		}
+		vs[n] = vs[i]
+		n++
+	}
+	return vs[:n]
+}
+
+func sortRecovered(rs []*Recovered) {
+	sort.Slice(rs, func(i, j int) bool {
+		return lessGap(rs[i].Gap, rs[j].Gap, false)
+	})
+}
+
+// feedFiles sends the files of fs to the returned channel.
+func feedFiles(fs []*File) <-chan *File {
+	q := make(chan *File)
+	go func() {
+		defer close(q)
+		for _, f := range fs {
+			q <- f
+		}
+	}()
+	return q
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRecoveredGaps(t *testing.T) {
+	data := []struct {
+		Seqs []int
+		Raw  []gapBounds
+		// Refilled gives the number of files refilled of each raw gap.
+		Refilled []uint64
+	}{
+		{
+			// the gap 2-5 is found then refilled by 3 and 4 (replay).
+			Seqs:     []int{1, 2, 5, 6, 3, 4, 7},
+			Raw:      []gapBounds{{2, 5}},
+			Refilled: []uint64{2},
+		},
+		{
+			// the gap 2-5 is partially refilled by 3.
+			Seqs:     []int{1, 2, 5, 6, 3, 7},
+			Raw:      []gapBounds{{2, 5}},
+			Refilled: []uint64{1},
+		},
+		{
+			// the gap 6-9 is never refilled and is not recovered.
+			Seqs:     []int{1, 2, 5, 6, 9, 4, 3, 10},
+			Raw:      []gapBounds{{2, 5}, {6, 9}},
+			Refilled: []uint64{2, 0},
+		},
+		{
+			// the files found twice are counted once.
+			Seqs:     []int{1, 2, 5, 3, 3, 6},
+			Raw:      []gapBounds{{2, 5}},
+			Refilled: []uint64{1},
+		},
+		{
+			Seqs: []int{1, 2, 3},
+		},
+	}
+	for i, d := range data {
+		var fs []*File
+		for _, s := range d.Seqs {
+			fs = append(fs, testFile("XYZ", uint64(s), false))
+		}
+		raw := rawGaps(fs, false, byUPI)
+		compareGaps(t, raw, d.Raw)
+
+		var want []gapBounds
+		for j, n := range d.Refilled {
+			if n > 0 {
+				want = append(want, d.Raw[j])
+			}
+		}
+		rs := recoveredGaps(raw, fs, false)
+		if len(rs) != len(want) {
+			t.Errorf("%d: want %d gaps recovered, got %d", i, len(want), len(rs))
+			continue
+		}
+		for j, r := range rs {
+			if r.Before != want[j].Before || r.After != want[j].After {
+				t.Errorf("%d: gap %d: want %d-%d, got %d-%d", i, j, want[j].Before, want[j].After, r.Before, r.After)
+			}
+			var refilled uint64
+			for k, g := range d.Raw {
+				if g == want[j] {
+					refilled = d.Refilled[k]
+				}
+			}
+			if r.Refilled != refilled {
+				t.Errorf("%d: gap %d: want %d files refilled, got %d", i, j, refilled, r.Refilled)
+			}
+		}
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,7 +36,7 @@ func listPaths(paths []string, period int, dtstart, dtend time.Time) ([]string,
 		dtend = time.Now()
 		dtstart = dtend.Add(Day * time.Duration(-period))
 	}
-	ps := make([]string, 0, len(paths)*DefaultPeriod)
+	paths, ps := splitLayouts(paths)
 	for dtstart.Before(dtend) {
 		y, d := fmt.Sprintf("%04d", dtstart.Year()), fmt.Sprintf("%03d", dtstart.YearDay())
 		for _, p := range paths {
@@ -42,19 +47,182 @@ func listPaths(paths []string, period int, dtstart, dtend time.Time) ([]string,
 	return ps, nil
 }
 
-func walkFiles(paths []string, upi string, max int) <-chan *File {
-	q := make(chan *File)
+// splitLayouts separates the paths organized by YYYY/DDD directories from the
+// flat ones (eg an export directory). The flat paths are walked entirely
+// whatever the period. A path without YYYY sub directories but whose sub
+// directories have YYYY sub directories (eg the parent directory of the
+// sources) is replaced by these sub directories. A warning is written on
+// stderr for each path that is not used as given.
+func splitLayouts(paths []string) ([]string, []string) {
+	var tree, flat []string
+	for _, p := range paths {
+		if hasYears(p) {
+			tree = append(tree, p)
+			continue
+		}
+		if ps := subRoots(p); len(ps) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: no YYYY sub directory, period selected in %d sub directories\n", p, len(ps))
+			tree = append(tree, ps...)
+			continue
+		}
+		if isDir(p) {
+			fmt.Fprintf(os.Stderr, "%s: no YYYY sub directory, walked entirely whatever the period\n", p)
+			flat = append(flat, p)
+		} else {
+			// a path that can not be read is reported by the walk.
+			tree = append(tree, p)
+		}
+	}
+	return tree, flat
+}
+
+// hasYears reports whether p has at least one YYYY sub directory.
+func hasYears(p string) bool {
+	es, err := ioutil.ReadDir(p)
+	if err != nil {
+		return false
+	}
+	for _, e := range es {
+		if isYear(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// subRoots gives the sub directories of p that have YYYY sub directories.
+func subRoots(p string) []string {
+	es, err := ioutil.ReadDir(p)
+	if err != nil {
+		return nil
+	}
+	var ps []string
+	for _, e := range es {
+		if !e.IsDir() || isYear(e) {
+			continue
+		}
+		if d := filepath.Join(p, e.Name()); hasYears(d) {
+			ps = append(ps, d)
+		}
+	}
+	return ps
+}
+
+func isYear(e os.FileInfo) bool {
+	if !e.IsDir() || len(e.Name()) != 4 {
+		return false
+	}
+	_, err := strconv.Atoi(e.Name())
+	return err == nil
+}
+
+func isDir(p string) bool {
+	i, err := os.Stat(p)
+	return err == nil && i.IsDir()
+}
+
+// selectPaths gives the paths to walk under paths either for the days of year
+// of the given year or for the period of time (see listPaths).
+func selectPaths(paths []string, period int, dtstart, dtend time.Time, year int, days []int) ([]string, error) {
+	if len(days) == 0 {
+		return listPaths(paths, period, dtstart, dtend)
+	}
+	if period > 0 || !dtstart.IsZero() || !dtend.IsZero() {
+		return nil, fmt.Errorf("days of year can't be set with a period or dates")
+	}
+	return listDays(paths, year, days)
+}
+
+// listDays gives the YYYY/DDD directories of the given days of year under
+// paths. The current year is used when year is zero.
+func listDays(paths []string, year int, days []int) ([]string, error) {
+	if year <= 0 {
+		year = time.Now().Year()
+	}
+	last := time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	paths, ps := splitLayouts(paths)
+	for _, d := range days {
+		if d < 1 || d > last {
+			return nil, fmt.Errorf("invalid day of year %d for %d (1-%d)", d, year, last)
+		}
+		y, d := fmt.Sprintf("%04d", year), fmt.Sprintf("%03d", d)
+		for _, p := range paths {
+			ps = append(ps, filepath.Join(p, y, d))
+		}
+	}
+	return ps, nil
+}
+
+// DefaultBuffer is the number of files that walkFiles can find before they
+// are consumed.
+const DefaultBuffer = 1024
+
+// scanOptions holds the options used to find the files into the archive.
+type scanOptions struct {
+	// UPI, when set, keeps only the files of this UPI.
+	UPI string
+	// Parallel is the maximum number of paths walked at the same time.
+	Parallel int
+	// Buffer is the size of the channel returned by walkFiles.
+	Buffer int
+	// PerRoot, when set, is the maximum number of paths walked at the same
+	// time under one of the Roots (eg to not overload a NFS share).
+	PerRoot int
+	Roots   []string
+	// NoRecurse, when set, only finds the files directly in the walked paths
+	// and skips their sub directories.
+	NoRecurse bool
+	// KeepTwins, when set, keeps the members of the zip archives that are also
+	// found on the filesystem (see preferFiles).
+	KeepTwins bool
+	// Ignored are the extensions of the files skipped in addition to the xml
+	// files (see -ignore-ext).
+	Ignored ExtList
+	// Parse are the options used to parse the filenames.
+	Parse parseOptions
+	// Rejected, when set, records the files discarded (see -strict).
+	Rejected *rejectList
+	// Drops, when set, writes the files discarded (see -drops).
+	Drops *dropWriter
+	// Profile, when set, records the time spent by the walk (see stats).
+	Profile *scanProfile
+}
+
+func walkFiles(paths []string, opts scanOptions) <-chan *File {
+	q := make(chan *File, opts.Buffer)
+	paths = nestPaths(paths, !opts.NoRecurse)
+	// no need of a semaphore and an errgroup when the paths are walked one
+	// after the other.
+	if len(paths) == 1 || opts.Parallel <= 1 {
+		go func() {
+			defer close(q)
+			for _, p := range paths {
+				findFiles(p, opts, q)
+			}
+		}()
+		return q
+	}
 	go func() {
 		defer close(q)
 
 		var group errgroup.Group
 
-		sema := make(chan struct{}, max)
+		sema := make(chan struct{}, opts.Parallel)
+		if opts.PerRoot > 0 {
+			for _, g := range groupPaths(paths, opts.Roots) {
+				dirs := g
+				group.Go(func() error {
+					return walkRoot(dirs, opts, sema, q)
+				})
+			}
+			group.Wait()
+			return
+		}
 		for _, a := range paths {
 			dir := a
 			sema <- struct{}{}
 			group.Go(func() error {
-				err := findFiles(dir, upi, q)
+				err := findFiles(dir, opts, q)
 				<-sema
 				return err
 			})
@@ -64,25 +232,220 @@ func walkFiles(paths []string, upi string, max int) <-chan *File {
 	return q
 }
 
-func findFiles(dir, upi string, queue chan<- *File) error {
-	return filepath.Walk(dir, func(p string, i os.FileInfo, err error) error {
+// nestPaths removes from paths the duplicated paths and, when the paths are
+// walked recursively, the paths under another one (eg /data/38/2019/058 with
+// /data/38) so that a file is only found once without recording every file
+// found. The order of the remaining paths is kept.
+func nestPaths(paths []string, recurse bool) []string {
+	abs := make([]string, len(paths))
+	for i, p := range paths {
+		a, err := filepath.Abs(p)
+		if err != nil {
+			a = filepath.Clean(p)
+		}
+		abs[i] = a
+	}
+	var (
+		ps  []string
+		sep = string(filepath.Separator)
+	)
+	for i, p := range paths {
+		keep := true
+		for j, o := range abs {
+			if i == j {
+				continue
+			}
+			// of two identical paths, the first one is kept.
+			if abs[i] == o {
+				keep = j > i
+			} else if recurse && strings.HasPrefix(abs[i], strings.TrimSuffix(o, sep)+sep) {
+				keep = false
+			}
+			if !keep {
+				break
+			}
+		}
+		if keep {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// walkRoot walks the paths of the same root with at most opts.PerRoot paths
+// walked at the same time.
+func walkRoot(paths []string, opts scanOptions, sema chan struct{}, q chan<- *File) error {
+	var group errgroup.Group
+
+	root := make(chan struct{}, opts.PerRoot)
+	for _, a := range paths {
+		dir := a
+		root <- struct{}{}
+		sema <- struct{}{}
+		group.Go(func() error {
+			err := findFiles(dir, opts, q)
+			<-sema
+			<-root
+			return err
+		})
+	}
+	return group.Wait()
+}
+
+// groupPaths groups paths by the root they are found under. Paths that are not
+// under one of roots are their own root. The order of the paths is preserved
+// in each group.
+func groupPaths(paths, roots []string) [][]string {
+	var (
+		gs [][]string
+		ix = make(map[string]int)
+	)
+	for _, p := range paths {
+		r := p
+		for _, o := range roots {
+			o = filepath.Clean(o)
+			if p != o && !strings.HasPrefix(p, o+string(filepath.Separator)) {
+				continue
+			}
+			if r == p || len(o) > len(r) {
+				r = o
+			}
+		}
+		i, ok := ix[r]
+		if !ok {
+			i = len(gs)
+			ix[r] = i
+			gs = append(gs, nil)
+		}
+		gs[i] = append(gs[i], p)
+	}
+	return gs
+}
+
+// filterFiles forwards to the returned channel the files of queue accepted by
+// keep.
+func filterFiles(queue <-chan *File, keep func(*File) bool) <-chan *File {
+	q := make(chan *File)
+	go func() {
+		defer close(q)
+		for f := range queue {
+			if keep(f) {
+				q <- f
+			}
+		}
+	}()
+	return q
+}
+
+// teeFiles sends each file of queue to both returned queues. Both queues
+// should be consumed concurrently.
+func teeFiles(queue <-chan *File) (<-chan *File, <-chan *File) {
+	q1, q2 := make(chan *File), make(chan *File)
+	go func() {
+		defer func() {
+			close(q1)
+			close(q2)
+		}()
+		for f := range queue {
+			q1 <- f
+			q2 <- f
+		}
+	}()
+	return q1, q2
+}
+
+// ExtList is a list of extensions given as a comma separated list. The flag
+// can be repeated.
+type ExtList []string
+
+func (e *ExtList) Set(v string) error {
+	for _, x := range strings.Split(v, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			continue
+		}
+		if !strings.HasPrefix(x, ".") {
+			x = "." + x
+		}
+		*e = append(*e, x)
+	}
+	return nil
+}
+
+func (e *ExtList) String() string {
+	return strings.Join(*e, ",")
+}
+
+// isIgnored reports whether p is skipped when the archive is scanned: the xml
+// files and the files with one of the extensions of exts.
+func isIgnored(p string, exts ExtList) bool {
+	x := filepath.Ext(p)
+	if strings.EqualFold(x, ".xml") {
+		return true
+	}
+	for _, e := range exts {
+		if strings.EqualFold(x, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// bySample accepts one file out of n, selected from the hash of its path so
+// that the same files are selected by each run.
+func bySample(n int) func(*File) bool {
+	return func(f *File) bool {
+		h := fnv.New32a()
+		io.WriteString(h, f.Path)
+		return h.Sum32()%uint32(n) == 0
+	}
+}
+
+// bySize accepts the files having a size between min and max. A value of zero
+// disables the corresponding bound.
+func bySize(min, max int64) func(*File) bool {
+	return func(f *File) bool {
+		return (min <= 0 || f.Size >= min) && (max <= 0 || f.Size <= max)
+	}
+}
+
+func findFiles(dir string, opts scanOptions, queue chan<- *File) error {
+	upi := opts.UPI
+	if opts.Profile != nil {
+		defer opts.Profile.walking(time.Now())
+	}
+	// the zip archives are read once the walk is done so that the files found
+	// on the filesystem are always preferred to their copy in a zip archive.
+	var zips []string
+	err := filepath.Walk(dir, func(p string, i os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if i.IsDir() {
+			if opts.NoRecurse && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// ignore xml files and the other sidecar files (see -ignore-ext)
+		if isIgnored(p, opts.Ignored) {
+			return nil
+		}
+		if isCompressedTar(p) {
+			if err := scanTar(p, opts, queue); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+			}
 			return nil
 		}
 		switch e := filepath.Ext(p); e {
-		case ".xml":
-			// ignore xml files
 		case ".zip":
+			zips = append(zips, p)
 		case ".tar":
-			fs, err := scanTar(p, upi)
-			if err != nil {
-				return err
-			}
-			for f := range fs {
-				queue <- f
+			// the files read before an error are kept and the walk goes on.
+			// A truncated archive can still be written and should be
+			// scanned again later.
+			if err := scanTar(p, opts, queue); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
 			}
 		case ".lst":
 			r, err := os.Open(p)
@@ -95,15 +458,16 @@ func findFiles(dir, upi string, queue chan<- *File) error {
 			s.Split(bufio.ScanLines)
 			for i := 0; s.Scan(); i++ {
 				p := s.Text()
-				if len(p) == 0 || filepath.Ext(p) == ".xml" {
+				if len(p) == 0 || isIgnored(p, opts.Ignored) {
 					continue
 				}
-				f, err := parseFilename(p, upi, 0)
+				f, err := parseFilename(p, 0, opts)
 				if err != nil {
 					continue
 				}
 				if f != nil {
-					queue <- f
+					f.Provenance = ProvList
+					sendFile(queue, f, opts.Profile)
 				}
 			}
 			return s.Err()
@@ -111,19 +475,55 @@ func findFiles(dir, upi string, queue chan<- *File) error {
 			if n := i.Name(); upi != "" && strings.Index(n, upi) < 0 {
 				return nil
 			}
-			f, err := parseFilename(p, upi, i.Size())
+			f, err := parseFilename(p, i.Size(), opts)
 			if err != nil {
 				return err
 			}
 			if f != nil {
-				queue <- f
+				f.Provenance = ProvLoose
+				sendFile(queue, f, opts.Profile)
 			}
 		}
 		return nil
 	})
+	for _, z := range zips {
+		if err := sendZip(z, opts, queue); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", z, err)
+		}
+	}
+	return err
 }
 
-func scanZip(p, upi string) (<-chan *File, error) {
+// sendZip sends to queue the files of the zip archive p that are not also
+// found on the filesystem, in the directory of the archive, unless
+// opts.KeepTwins is set. Only the directories with a zip archive are read a
+// second time.
+func sendZip(p string, opts scanOptions, queue chan<- *File) error {
+	loose := make(map[string]struct{})
+	if !opts.KeepTwins {
+		es, err := ioutil.ReadDir(filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			if !e.IsDir() {
+				loose[e.Name()] = struct{}{}
+			}
+		}
+	}
+	q, err := scanZip(p, opts)
+	if err != nil {
+		return err
+	}
+	for f := range q {
+		if _, ok := loose[filepath.Base(f.Path)]; !ok {
+			sendFile(queue, f, opts.Profile)
+		}
+	}
+	return nil
+}
+
+func scanZip(p string, opts scanOptions) (<-chan *File, error) {
 	rc, err := zip.OpenReader(p)
 	if err != nil {
 		return nil, err
@@ -134,15 +534,18 @@ func scanZip(p, upi string) (<-chan *File, error) {
 			rc.Close()
 			close(q)
 		}()
-		for _, f := range rc.File {
-			if filepath.Ext(f.Name) == ".xml" {
+		for _, z := range rc.File {
+			if isIgnored(z.Name, opts.Ignored) || z.FileInfo().IsDir() {
 				continue
 			}
-			f, err := parseFilename(f.Name, upi, int64(f.UncompressedSize64))
+			// the names of the members always use slashes.
+			f, err := parseFilename(filepath.FromSlash(z.Name), int64(z.UncompressedSize64), opts)
 			if err != nil {
 				break
 			}
 			if f != nil {
+				f.Stored = int64(z.CompressedSize64)
+				f.Provenance = ProvZip
 				q <- f
 			}
 		}
@@ -150,40 +553,135 @@ func scanZip(p, upi string) (<-chan *File, error) {
 	return q, nil
 }
 
-func scanTar(p, upi string) (<-chan *File, error) {
+// MaxTarDepth is the maximum level of tar archives nested into another tar
+// archive that scanTar goes through.
+const MaxTarDepth = 4
+
+// ErrTruncated is returned when the end of an archive is reached in the
+// middle of one of its members, eg when the archive is still being written.
+var ErrTruncated = errors.New("archive truncated")
+
+// scanTar sends to queue the files of the tar archive p. The files read before
+// an error occurs are sent to queue.
+func scanTar(p string, opts scanOptions, queue chan<- *File) error {
 	r, err := os.Open(p)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	q := make(chan *File)
-	go func() {
-		defer func() {
-			r.Close()
-			close(q)
-		}()
-		t := tar.NewReader(r)
-		for {
-			h, err := t.Next()
-			if err == io.EOF {
-				break
+	defer r.Close()
+	if !isCompressedTar(p) {
+		return readTar(r, opts, queue, 0)
+	}
+	z, err := decompress(p, r)
+	if err != nil {
+		return tarError(err)
+	}
+	defer z.Close()
+	return readTar(z, opts, queue, 0)
+}
+
+// readTar sends to q the files of the tar archive read from r. A member that
+// can not be read (a nested archive that is not valid or a filename that can
+// not be parsed) is skipped and the members following it are still read: only
+// an error of the archive itself stops readTar.
+func readTar(r io.Reader, opts scanOptions, q chan<- *File, depth int) error {
+	t := tar.NewReader(r)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return tarError(err)
+		}
+		switch {
+		case isIgnored(h.Name, opts.Ignored):
+			continue
+		case isTar(h.Name):
+			if depth >= MaxTarDepth {
+				continue
 			}
-			if err != nil {
-				break
+			if err := readTar(t, opts, q, depth+1); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", h.Name, err)
 			}
-			if filepath.Ext(h.Name) == ".xml" {
+			continue
+		case isCompressedTar(h.Name):
+			if depth >= MaxTarDepth {
 				continue
 			}
-			f, err := parseFilename(h.Name, upi, h.Size)
+			z, err := decompress(h.Name, t)
 			if err != nil {
-				break
-			}
-			if f != nil {
-				q <- f
+				fmt.Fprintf(os.Stderr, "%s: %s\n", h.Name, tarError(err))
+				continue
 			}
-			if _, err := io.CopyN(ioutil.Discard, t, h.Size); err != nil {
-				break
+			err = readTar(z, opts, q, depth+1)
+			z.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", h.Name, err)
 			}
+			continue
 		}
-	}()
-	return q, nil
+		f, err := parseFilename(h.Name, h.Size, opts)
+		if err != nil {
+			continue
+		}
+		if _, err := io.CopyN(ioutil.Discard, t, h.Size); err != nil {
+			return tarError(err)
+		}
+		if f != nil {
+			f.Provenance = ProvTar
+			sendFile(q, f, opts.Profile)
+		}
+	}
+	return nil
+}
+
+func tarError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %s", ErrTruncated, err)
+	}
+	return err
+}
+
+func isTar(n string) bool {
+	return filepath.Ext(n) == ".tar"
+}
+
+// Decompressor gives a reader of the decompressed content of r.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// decompressors gives by suffix the Decompressor of the compressed tar
+// archives. The xz decompressor is only available when upifinder is built with
+// the xz tag.
+var decompressors = map[string]Decompressor{
+	".tgz":     gunzip,
+	".tar.gz":  gunzip,
+	".tbz2":    bunzip2,
+	".tar.bz2": bunzip2,
+}
+
+func gunzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func bunzip2(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bzip2.NewReader(r)), nil
+}
+
+func decompress(n string, r io.Reader) (io.ReadCloser, error) {
+	for s, d := range decompressors {
+		if strings.HasSuffix(n, s) {
+			return d(r)
+		}
+	}
+	return nil, fmt.Errorf("%s: unsupported compression", n)
+}
+
+func isCompressedTar(n string) bool {
+	for s := range decompressors {
+		if strings.HasSuffix(n, s) {
+			return true
+		}
+	}
+	return false
 }
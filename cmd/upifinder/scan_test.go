@@ -0,0 +1,770 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive creates under dir the files of the given names into the
+// directory of source 38 for the day 2019/058 and gives this directory.
+func testArchive(t testing.TB, dir string, names ...string) string {
+	t.Helper()
+	day := filepath.Join(dir, "38", "2019", "058")
+	if err := os.MkdirAll(day, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range names {
+		if err := ioutil.WriteFile(filepath.Join(day, n), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return day
+}
+
+// testNames gives the names of n files of the UPI upi.
+func testNames(upi string, n int) []string {
+	var ns []string
+	for i := 0; i < n; i++ {
+		ns = append(ns, fmt.Sprintf("0038_%s_1_%d_20190227_101010_00.dat", upi, i))
+	}
+	return ns
+}
+
+func collectPaths(queue <-chan *File) []string {
+	var ps []string
+	for f := range queue {
+		ps = append(ps, f.Path)
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+func TestWalkFilesBuffer(t *testing.T) {
+	dir := t.TempDir()
+	testArchive(t, dir, append(testNames("AAA", 50), testNames("BBB", 50)...)...)
+	roots := []string{filepath.Join(dir, "38")}
+
+	want := collectPaths(walkFiles(roots, scanOptions{Parallel: 1}))
+	if len(want) != 100 {
+		t.Fatalf("want 100 files, got %d", len(want))
+	}
+	for _, n := range []int{1, 7, DefaultBuffer} {
+		got := collectPaths(walkFiles(roots, scanOptions{Parallel: 8, Buffer: n}))
+		if len(got) != len(want) {
+			t.Errorf("buffer %d: want %d files, got %d", n, len(want), len(got))
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("buffer %d: want %s, got %s", n, want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestScanZipStored(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "archive.zip")
+	w, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("data", 1000)
+	z := zip.NewWriter(w)
+	for _, n := range testNames("XYZ", 2) {
+		m, err := z.CreateHeader(&zip.FileHeader{Name: n, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Write([]byte(data))
+	}
+	z.Close()
+	w.Close()
+
+	q, err := scanZip(p, scanOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := &Coze{UPI: "38/XYZ"}
+	for f := range q {
+		if f.Size != int64(len(data)) || f.Stored <= 0 || f.Stored >= f.Size || f.Provenance != ProvZip {
+			t.Errorf("%s: unexpected sizes %d/%d", f.Path, f.Stored, f.Size)
+		}
+		c.Update(f)
+	}
+	if c.Count != 2 || c.Size != uint64(2*len(data)) {
+		t.Fatalf("want 2 files of %d bytes, got %d files of %d bytes", len(data), c.Count, c.Size)
+	}
+	if r := c.Compression(); r <= 0 || r >= 1 || r != float64(c.Stored)/float64(c.Size) {
+		t.Errorf("unexpected compression %f (%d/%d)", r, c.Stored, c.Size)
+	}
+
+	// a loose file is stored with its size.
+	f := testFile("XYZ", 3, false)
+	f.Stored = f.Size
+	loose := &Coze{UPI: "38/XYZ"}
+	loose.Update(f)
+	if loose.Compression() != 1 {
+		t.Errorf("loose file: want compression 1, got %f", loose.Compression())
+	}
+	if r := (&Coze{}).Compression(); r != 0 {
+		t.Errorf("no file: want compression 0, got %f", r)
+	}
+}
+
+func TestGroupPaths(t *testing.T) {
+	data := []struct {
+		Paths []string
+		Roots []string
+		Want  [][]string
+	}{
+		{
+			Paths: []string{"/data/38/2019/058", "/data/39/2019/058", "/data/38/2019/059"},
+			Roots: []string{"/data/38", "/data/39/"},
+			Want:  [][]string{{"/data/38/2019/058", "/data/38/2019/059"}, {"/data/39/2019/058"}},
+		},
+		{
+			// the longest root is used and a path out of the roots is its own
+			// root.
+			Paths: []string{"/data/38/2019/058", "/data/39/2019/058", "/export", "/data/38"},
+			Roots: []string{"/data", "/data/38"},
+			Want:  [][]string{{"/data/38/2019/058", "/data/38"}, {"/data/39/2019/058"}, {"/export"}},
+		},
+		{
+			// a root is not the prefix of a path of another directory.
+			Paths: []string{"/data/38/2019/058", "/data/380/2019/058"},
+			Roots: []string{"/data/38", "/data/380"},
+			Want:  [][]string{{"/data/38/2019/058"}, {"/data/380/2019/058"}},
+		},
+	}
+	for i, d := range data {
+		var paths, roots []string
+		for _, p := range d.Paths {
+			paths = append(paths, filepath.FromSlash(p))
+		}
+		for _, r := range d.Roots {
+			roots = append(roots, filepath.FromSlash(r))
+		}
+		got := groupPaths(paths, roots)
+		if fmt.Sprint(got) != filepath.FromSlash(fmt.Sprint(d.Want)) {
+			t.Errorf("%d: want %v, got %v", i, d.Want, got)
+		}
+	}
+}
+
+func TestWalkFilesPerRoot(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		roots []string
+		paths []string
+	)
+	for _, src := range []string{"38", "39"} {
+		roots = append(roots, filepath.Join(dir, src))
+		for _, d := range []string{"057", "058", "059"} {
+			day := filepath.Join(dir, src, "2019", d)
+			if err := os.MkdirAll(day, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range testNames("XYZ"+d, 5) {
+				if err := ioutil.WriteFile(filepath.Join(day, n), []byte("data"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			paths = append(paths, day)
+		}
+	}
+	want := collectPaths(walkFiles(paths, scanOptions{Parallel: 1}))
+	if len(want) != 30 {
+		t.Fatalf("want 30 files, got %d", len(want))
+	}
+	for _, n := range []int{1, 2, 8} {
+		got := collectPaths(walkFiles(paths, scanOptions{Parallel: 4, PerRoot: n, Roots: roots}))
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("per root %d: want %d files, got %d", n, len(want), len(got))
+		}
+	}
+}
+
+func TestBySize(t *testing.T) {
+	var fs []*File
+	for _, n := range []int64{0, 10, 100, 1000} {
+		f := testFile("XYZ", uint64(n), false)
+		f.Size = n
+		fs = append(fs, f)
+	}
+	data := []struct {
+		Min, Max int64
+		Want     []int64
+	}{
+		{Want: []int64{0, 10, 100, 1000}},
+		{Min: 10, Want: []int64{10, 100, 1000}},
+		{Max: 100, Want: []int64{0, 10, 100}},
+		{Min: 10, Max: 100, Want: []int64{10, 100}},
+		{Min: 11, Max: 99},
+		{Min: -1, Max: -1, Want: []int64{0, 10, 100, 1000}},
+	}
+	for _, d := range data {
+		var got []int64
+		for f := range filterFiles(feedFiles(fs), bySize(d.Min, d.Max)) {
+			got = append(got, f.Size)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(d.Want) {
+			t.Errorf("%d-%d: want %v, got %v", d.Min, d.Max, d.Want, got)
+		}
+	}
+}
+
+func TestWalkFilesSequential(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, d := range []string{"056", "057", "058", "059"} {
+		day := filepath.Join(dir, "38", "2019", d)
+		if err := os.MkdirAll(day, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range append(testNames("AAA", 20+i), testNames("BBB", 10*i)...) {
+			if err := ioutil.WriteFile(filepath.Join(day, n), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths = append(paths, day)
+	}
+
+	// the paths are walked in the calling order with Parallel 1 and at the same
+	// time otherwise.
+	report := func(parallel int) ([]string, string) {
+		ps := collectPaths(walkFiles(paths, scanOptions{Parallel: parallel}))
+		rs := countFiles(walkFiles(paths, scanOptions{Parallel: parallel}), 1, countOptions{})
+		var buf bytes.Buffer
+		reportWalkResults(&buf, sortCozes(rs, false), walkOptions{CSV: true, Now: testEpoch})
+		return ps, buf.String()
+	}
+	want, walk := report(1)
+	if len(want) != 146 {
+		t.Fatalf("want 146 files, got %d", len(want))
+	}
+	got, other := report(4)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("concurrent walk: want %d files, got %d", len(want), len(got))
+	}
+	if other != walk {
+		t.Errorf("concurrent walk: want\n%s\ngot\n%s", walk, other)
+	}
+}
+
+func BenchmarkWalkFilesBuffer(b *testing.B) {
+	dir := b.TempDir()
+	testArchive(b, dir, testNames("AAA", 2000)...)
+	roots := []string{filepath.Join(dir, "38")}
+	for _, n := range []int{0, 64, DefaultBuffer} {
+		b.Run(fmt.Sprintf("buffer-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				countShard(walkFiles(roots, scanOptions{Parallel: 1, Buffer: n}), countOptions{})
+			}
+		})
+	}
+}
+
+func TestNestPaths(t *testing.T) {
+	data := []struct {
+		Paths   []string
+		Recurse bool
+		Want    []string
+	}{
+		{
+			Paths:   []string{"/data/38", "/data/38/2019/058", "/data/39"},
+			Recurse: true,
+			Want:    []string{"/data/38", "/data/39"},
+		},
+		{
+			Paths:   []string{"/data/38/2019/058", "/data/38/", "/data/38"},
+			Recurse: true,
+			Want:    []string{"/data/38/"},
+		},
+		{
+			Paths:   []string{"/data/38", "/data/38/2019/058", "/data/38"},
+			Recurse: false,
+			Want:    []string{"/data/38", "/data/38/2019/058"},
+		},
+		{
+			Paths:   []string{"/data/380", "/data/38"},
+			Recurse: true,
+			Want:    []string{"/data/380", "/data/38"},
+		},
+		{
+			Paths:   []string{"/data/38", "/"},
+			Recurse: true,
+			Want:    []string{"/"},
+		},
+	}
+	for _, d := range data {
+		got := nestPaths(d.Paths, d.Recurse)
+		if fmt.Sprint(got) != fmt.Sprint(d.Want) {
+			t.Errorf("%v: want %v, got %v", d.Paths, d.Want, got)
+		}
+	}
+}
+
+func TestWalkFilesOverlap(t *testing.T) {
+	dir := t.TempDir()
+	day := testArchive(t, dir, testNames("AAA", 3)...)
+	for _, par := range []int{1, 8} {
+		ps := collectPaths(walkFiles([]string{filepath.Join(dir, "38"), day, day}, scanOptions{Parallel: par}))
+		if len(ps) != 3 {
+			t.Errorf("parallel %d: want 3 files, got %d", par, len(ps))
+		}
+	}
+	ps := collectPaths(walkFiles([]string{day, filepath.Join(dir, "38")}, scanOptions{Parallel: 1, NoRecurse: true}))
+	if len(ps) != 3 {
+		t.Errorf("no recurse: want 3 files, got %d", len(ps))
+	}
+}
+
+func TestWalkFilesNoRecurse(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		sub    = filepath.Join(dir, "sub")
+		deeper = filepath.Join(sub, "deeper")
+	)
+	os.MkdirAll(deeper, 0755)
+	for p, n := range map[string]int{dir: 2, sub: 3, deeper: 1} {
+		for _, n := range testNames(filepath.Base(p), n) {
+			ioutil.WriteFile(filepath.Join(p, n), []byte("data"), 0644)
+		}
+	}
+	// the archives directly in the walked path are read.
+	ms := testTarMembers(t, [2]string{testNames("TAR", 1)[0], "member data"})
+	ioutil.WriteFile(filepath.Join(dir, "archive.tar"), ms, 0644)
+
+	data := []struct {
+		Paths     []string
+		NoRecurse bool
+		Files     int
+	}{
+		{Paths: []string{dir}, Files: 7},
+		{Paths: []string{dir}, NoRecurse: true, Files: 3},
+		{Paths: []string{sub}, NoRecurse: true, Files: 3},
+		{Paths: []string{deeper}, NoRecurse: true, Files: 1},
+		// the nested paths are all walked without recursion.
+		{Paths: []string{dir, sub}, Files: 7},
+		{Paths: []string{dir, deeper}, NoRecurse: true, Files: 4},
+	}
+	for _, d := range data {
+		for _, par := range []int{1, 4} {
+			ps := collectPaths(walkFiles(d.Paths, scanOptions{Parallel: par, NoRecurse: d.NoRecurse}))
+			if len(ps) != d.Files {
+				t.Errorf("%v (no recurse: %t, parallel %d): want %d files, got %d", d.Paths, d.NoRecurse, par, d.Files, len(ps))
+			}
+		}
+	}
+}
+
+func TestIgnoreExt(t *testing.T) {
+	var exts ExtList
+	set := flag.NewFlagSet("walk", flag.ContinueOnError)
+	set.Var(&exts, "ignore-ext", "")
+	if err := set.Parse([]string{"-ignore-ext", "md5, .sig,", "-ignore-ext", "TMP"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := exts.String(); got != ".md5,.sig,.TMP" {
+		t.Errorf("want .md5,.sig,.TMP, got %s", got)
+	}
+
+	data := []struct {
+		Path string
+		Want bool
+	}{
+		{Path: "0038_XYZ_1_0_20190227_101010_00.dat", Want: false},
+		{Path: "0038_XYZ_1_0_20190227_101010_00.dat.xml", Want: true},
+		{Path: "0038_XYZ_1_0_20190227_101010_00.XML", Want: true},
+		{Path: "0038_XYZ_1_0_20190227_101010_00.dat.md5", Want: true},
+		{Path: "0038_XYZ_1_0_20190227_101010_00.dat.MD5", Want: true},
+		{Path: "0038_XYZ_1_0_20190227_101010_00.dat.tmp", Want: true},
+		{Path: "0038_XYZ_1_0_20190227_101010_00.dat.sha1", Want: false},
+		{Path: filepath.Join("md5", "0038_XYZ_1_0_20190227_101010_00.dat"), Want: false},
+	}
+	for _, d := range data {
+		if got := isIgnored(d.Path, exts); got != d.Want {
+			t.Errorf("%s: want ignored %t, got %t", d.Path, d.Want, got)
+		}
+	}
+	if isIgnored(data[3].Path, nil) {
+		t.Errorf("%s: ignored without -ignore-ext", data[3].Path)
+	}
+
+	// the sidecars are found as data files when their extension is not
+	// ignored.
+	names := testNames("XYZ", 2)
+	dir := t.TempDir()
+	testArchive(t, dir, names[0], names[1], names[0]+".md5", names[1]+".sig", names[1]+".xml")
+	for _, w := range []struct {
+		Exts  ExtList
+		Files int
+	}{
+		{Files: 4},
+		{Exts: ExtList{".md5"}, Files: 3},
+		{Exts: exts, Files: 2},
+	} {
+		if fs := collectPaths(walkFiles([]string{dir}, scanOptions{Parallel: 1, Ignored: w.Exts})); len(fs) != w.Files {
+			t.Errorf("%v: want %d files, got %d", w.Exts, w.Files, len(fs))
+		}
+	}
+}
+
+func scanArchive(t *testing.T, p string) []*File {
+	t.Helper()
+	var (
+		fs  []*File
+		q   = make(chan *File)
+		err error
+	)
+	go func() {
+		defer close(q)
+		err = scanTar(p, scanOptions{}, q)
+	}()
+	for f := range q {
+		fs = append(fs, f)
+	}
+	if err != nil {
+		t.Fatalf("%s: unexpected error: %s", p, err)
+	}
+	return fs
+}
+
+func checkArchive(t *testing.T, p string, fs []*File) {
+	t.Helper()
+	if len(fs) != 3 {
+		t.Fatalf("%s: want 3 files (xml skipped), got %d", p, len(fs))
+	}
+	for i, f := range fs {
+		if f.Sequence != uint64(i+1) || f.Info != "XYZ" || f.Provenance != ProvTar || f.Size != 6 {
+			t.Errorf("%s: unexpected file %+v", p, *f)
+		}
+	}
+}
+
+// testdata/tb.tar holds three files of the UPI XYZ (sequence counters 1 to 3)
+// and a xml file. The other archives of testdata are compressed copies of it.
+func TestScanTarCompressed(t *testing.T) {
+	buf, err := ioutil.ReadFile(filepath.Join("testdata", "tb.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := filepath.Join(t.TempDir(), "archive.tgz")
+	w, err := os.Create(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := gzip.NewWriter(w)
+	z.Write(buf)
+	z.Close()
+	w.Close()
+
+	for _, p := range []string{filepath.Join("testdata", "tb.tar"), filepath.Join("testdata", "archive.tar.bz2"), gz} {
+		checkArchive(t, p, scanArchive(t, p))
+	}
+}
+
+// testTarMembers gives a tar archive with the members of the given names and
+// contents.
+func testTarMembers(t *testing.T, ms ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range ms {
+		h := tar.Header{
+			Name: m[0],
+			Mode: 0644,
+			Size: int64(len(m[1])),
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(m[1]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScanTarNested(t *testing.T) {
+	defer func(f *os.File) { os.Stderr = f }(os.Stderr)
+	os.Stderr, _ = os.Open(os.DevNull)
+
+	inner := testTarMembers(t, [2]string{"0038_XYZ_1_2_20190227_101010_00.dat", "data"})
+	buf := testTarMembers(t,
+		[2]string{"0038_XYZ_1_1_20190227_101010_00.dat", "data"},
+		[2]string{"bad.tar.gz", strings.Repeat("garbage", 100)},
+		[2]string{"inner.tar", string(inner)},
+		[2]string{"broken.tar", strings.Repeat("garbage", 100)},
+		[2]string{"0038_XYZ_1_ten_20190227_101010_00.dat", "data"},
+		[2]string{"0038_XYZ_1_3_20190227_101010_00.dat", "data"},
+	)
+	p := filepath.Join(t.TempDir(), "outer.tar")
+	if err := ioutil.WriteFile(p, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := scanArchive(t, p)
+	if len(fs) != 3 {
+		t.Fatalf("want 3 files (bad members skipped), got %d", len(fs))
+	}
+	for i, f := range fs {
+		if f.Sequence != uint64(i+1) {
+			t.Errorf("want sequence %d, got %d", i+1, f.Sequence)
+		}
+	}
+}
+
+func TestScanTarTruncated(t *testing.T) {
+	buf := testTarMembers(t,
+		[2]string{"0038_XYZ_1_1_20190227_101010_00.dat", strings.Repeat("data", 100)},
+		[2]string{"0038_XYZ_1_2_20190227_101010_00.dat", strings.Repeat("data", 100)},
+		[2]string{"0038_XYZ_1_3_20190227_101010_00.dat", strings.Repeat("data", 1000)},
+	)
+	dir := t.TempDir()
+	// the archive is cut in the middle of the data of its last member, as if it
+	// was still being written.
+	p := filepath.Join(dir, "truncated.tar")
+	if err := ioutil.WriteFile(p, buf[:2*1024+1024], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		fs []*File
+		q  = make(chan *File)
+		err error
+	)
+	go func() {
+		defer close(q)
+		err = scanTar(p, scanOptions{}, q)
+	}()
+	for f := range q {
+		fs = append(fs, f)
+	}
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("want truncated error, got %v", err)
+	}
+	if len(fs) != 2 {
+		t.Errorf("want the 2 complete members, got %d", len(fs))
+	}
+
+	// an archive ending without its end of archive blocks is complete.
+	p = filepath.Join(dir, "unterminated.tar")
+	if err := ioutil.WriteFile(p, buf[:len(buf)-1024], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fs := scanArchive(t, p); len(fs) != 3 {
+		t.Errorf("want 3 files, got %d", len(fs))
+	}
+
+	for _, e := range []error{io.EOF, io.ErrUnexpectedEOF} {
+		if err := tarError(e); !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: want truncated error, got %v", e, err)
+		}
+	}
+	if err := tarError(tar.ErrHeader); errors.Is(err, ErrTruncated) {
+		t.Errorf("%s: unexpected truncated error", err)
+	}
+}
+
+func TestWalkFilesZipTwin(t *testing.T) {
+	dir := t.TempDir()
+	names := testNames("XYZ", 2)
+	day := testArchive(t, dir, names[0])
+
+	w, err := os.Create(filepath.Join(day, "archive.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(w)
+	for _, n := range names {
+		m, _ := z.Create("data/" + n)
+		m.Write([]byte("member data"))
+	}
+	z.Close()
+	w.Close()
+
+	for _, par := range []int{1, 8} {
+		for i := 0; i < 5; i++ {
+			var fs []*File
+			for f := range walkFiles([]string{filepath.Join(dir, "38"), filepath.Join(dir, "38", "2019")}, scanOptions{Parallel: par}) {
+				fs = append(fs, f)
+			}
+			sort.Slice(fs, func(i, j int) bool { return fs[i].Sequence < fs[j].Sequence })
+			if len(fs) != 2 {
+				t.Fatalf("parallel %d: want 2 files, got %d", par, len(fs))
+			}
+			if f := fs[0]; f.Provenance != ProvLoose || f.Size != 4 {
+				t.Errorf("parallel %d: loose twin not kept: %+v", par, *f)
+			}
+			if f := fs[1]; f.Provenance != ProvZip || f.Size != 11 {
+				t.Errorf("parallel %d: unexpected member: %+v", par, *f)
+			}
+		}
+	}
+}
+
+func TestListPathsLayouts(t *testing.T) {
+	dir := t.TempDir()
+	testArchive(t, dir, testNames("AAA", 2)...)
+	export := filepath.Join(dir, "export")
+	os.MkdirAll(export, 0755)
+	for _, n := range testNames("BBB", 3) {
+		ioutil.WriteFile(filepath.Join(export, n), []byte("data"), 0644)
+	}
+	var (
+		dtstart = time.Date(2019, 2, 27, 0, 0, 0, 0, time.UTC)
+		dtend   = dtstart.Add(Day)
+		day     = filepath.Join("2019", "058")
+	)
+	data := []struct {
+		Paths []string
+		Want  []string
+		Files int
+	}{
+		{
+			Paths: []string{filepath.Join(dir, "38"), export},
+			Want:  []string{filepath.Join(dir, "38", day), export},
+			Files: 5,
+		},
+		{
+			// one level too high: the sub directories are used as roots.
+			Paths: []string{dir},
+			Want:  []string{filepath.Join(dir, "38", day)},
+			Files: 2,
+		},
+	}
+	for _, d := range data {
+		ps, err := listPaths(d.Paths, 0, dtstart, dtend)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %s", d.Paths, err)
+		}
+		sort.Strings(ps)
+		sort.Strings(d.Want)
+		if fmt.Sprint(ps) != fmt.Sprint(d.Want) {
+			t.Errorf("%v: want %v, got %v", d.Paths, d.Want, ps)
+		}
+		if fs := collectPaths(walkFiles(ps, scanOptions{Parallel: 2})); len(fs) != d.Files {
+			t.Errorf("%v: want %d files, got %d", d.Paths, d.Files, len(fs))
+		}
+	}
+}
+
+func TestSelectPathsDays(t *testing.T) {
+	dir := t.TempDir()
+	testArchive(t, dir)
+	root := filepath.Join(dir, "38")
+
+	data := []struct {
+		Year int
+		Days []int
+		Want []string
+		Err  bool
+	}{
+		{
+			Year: 2019,
+			Days: []int{58, 1},
+			Want: []string{filepath.Join(root, "2019", "058"), filepath.Join(root, "2019", "001")},
+		},
+		{
+			// leap year.
+			Year: 2020,
+			Days: []int{366},
+			Want: []string{filepath.Join(root, "2020", "366")},
+		},
+		{Year: 2019, Days: []int{366}, Err: true},
+		{Year: 2019, Days: []int{0}, Err: true},
+		{Year: 2019, Days: []int{1, 400}, Err: true},
+	}
+	for _, d := range data {
+		ps, err := selectPaths([]string{root}, 0, time.Time{}, time.Time{}, d.Year, d.Days)
+		if d.Err {
+			if err == nil {
+				t.Errorf("%d %v: want error, got %v", d.Year, d.Days, ps)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d %v: unexpected error: %s", d.Year, d.Days, err)
+			continue
+		}
+		if fmt.Sprint(ps) != fmt.Sprint(d.Want) {
+			t.Errorf("%d %v: want %v, got %v", d.Year, d.Days, d.Want, ps)
+		}
+	}
+
+	// the current year when none is given.
+	ps, err := selectPaths([]string{root}, 0, time.Time{}, time.Time{}, 0, []int{1})
+	want := filepath.Join(root, fmt.Sprintf("%04d", time.Now().Year()), "001")
+	if err != nil || len(ps) != 1 || ps[0] != want {
+		t.Errorf("current year: want %s, got %v (%v)", want, ps, err)
+	}
+
+	// days of year can not be mixed with a period or dates.
+	if _, err := selectPaths([]string{root}, 2, time.Time{}, time.Time{}, 2019, []int{1}); err == nil {
+		t.Errorf("days with period: want error, got none")
+	}
+	dtstart := time.Date(2019, 2, 27, 0, 0, 0, 0, time.UTC)
+	if _, err := selectPaths([]string{root}, 0, dtstart, time.Time{}, 2019, []int{1}); err == nil {
+		t.Errorf("days with start date: want error, got none")
+	}
+}
+
+func TestPreferFiles(t *testing.T) {
+	dir := t.TempDir()
+	names := testNames("XYZ", 2)
+	day := testArchive(t, dir, names[0])
+	// the lst file gives the same file than the one on the filesystem and the
+	// one in the zip archive.
+	ioutil.WriteFile(filepath.Join(day, "files.lst"), []byte(filepath.Join("/export", names[0])+"\n"), 0644)
+	w, _ := os.Create(filepath.Join(day, "archive.zip"))
+	z := zip.NewWriter(w)
+	for _, n := range names {
+		m, _ := z.Create(n)
+		m.Write([]byte("member data"))
+	}
+	z.Close()
+	w.Close()
+
+	data := []struct {
+		Prefer string
+		Twins  bool
+		Want   []string
+	}{
+		{Prefer: "", Twins: false, Want: []string{"0/loose", "0/lst", "1/zip"}},
+		{Prefer: "", Twins: true, Want: []string{"0/loose", "0/lst", "1/zip"}},
+		{Prefer: ProvList, Twins: true, Want: []string{"0/lst", "1/zip"}},
+		{Prefer: ProvZip, Twins: true, Want: []string{"0/zip", "1/zip"}},
+		{Prefer: ProvZip, Twins: false, Want: []string{"0/loose", "0/lst", "1/zip"}},
+	}
+	for _, d := range data {
+		var (
+			buf   bytes.Buffer
+			got   []string
+			queue = walkFiles([]string{day}, scanOptions{Parallel: 1, KeepTwins: d.Twins})
+		)
+		for f := range preferFiles(queue, d.Prefer, &buf) {
+			got = append(got, fmt.Sprintf("%d/%s", f.Sequence, f.Provenance))
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(d.Want) {
+			t.Errorf("prefer %q (twins: %t): want %v, got %v", d.Prefer, d.Twins, d.Want, got)
+		}
+		if report := buf.String(); !strings.Contains(report, "38/XYZ/0: found in") {
+			t.Errorf("prefer %q (twins: %t): conflict not reported: %q", d.Prefer, d.Twins, report)
+		}
+	}
+}
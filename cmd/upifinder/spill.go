@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// SpillBuckets is the number of temporary files used to spill the files found
+// by walk (see -spill).
+const SpillBuckets = 64
+
+// spillFiles aggregates the files of queue by UPI like countFiles but with less
+// memory: the files are first written into temporary files in dir (the files of
+// an UPI always go to the same temporary file) and then each temporary file is
+// aggregated on its own. Once a temporary file is aggregated, the ranges of
+// sequence counters of its Coze are released (see Coze.compact).
+//
+// Only the ranges of sequence counters, that grow with the gaps and the
+// duplicates of an UPI, are bounded: at most the ranges of the UPI of one
+// temporary file are in memory at the same time. The returned map still has
+// one Coze (of fixed size) by UPI.
+func spillFiles(queue <-chan *File, dir string, opts countOptions) (map[string]*Coze, error) {
+	var (
+		bs = make([]*os.File, SpillBuckets)
+		ws = make([]*bufio.Writer, SpillBuckets)
+		es = make([]*gob.Encoder, SpillBuckets)
+	)
+	defer func() {
+		for _, b := range bs {
+			if b == nil {
+				continue
+			}
+			b.Close()
+			os.Remove(b.Name())
+		}
+	}()
+	for i := range bs {
+		b, err := ioutil.TempFile(dir, "upifinder-*.spill")
+		if err != nil {
+			drainFiles(queue)
+			return nil, err
+		}
+		bs[i], ws[i] = b, bufio.NewWriter(b)
+		es[i] = gob.NewEncoder(ws[i])
+	}
+	for f := range queue {
+		h := fnv.New32a()
+		io.WriteString(h, f.String())
+		if err := es[h.Sum32()%SpillBuckets].Encode(f); err != nil {
+			drainFiles(queue)
+			return nil, err
+		}
+	}
+
+	rs := make(map[string]*Coze)
+	for i, b := range bs {
+		if err := ws[i].Flush(); err != nil {
+			return nil, err
+		}
+		if _, err := b.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		cs, err := countSpilled(bufio.NewReader(b), opts)
+		if err != nil {
+			return nil, err
+		}
+		for k, c := range cs {
+			c.compact()
+			rs[k] = c
+		}
+	}
+	return rs, nil
+}
+
+func countSpilled(r io.Reader, opts countOptions) (map[string]*Coze, error) {
+	var (
+		err error
+		q   = make(chan *File)
+		d   = gob.NewDecoder(r)
+	)
+	go func() {
+		defer close(q)
+		for {
+			var f File
+			if err = d.Decode(&f); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				return
+			}
+			q <- &f
+		}
+	}()
+	cs := countShard(q, opts)
+	return cs, err
+}
+
+// drainFiles consumes queue to not leak the goroutines of walkFiles.
+func drainFiles(queue <-chan *File) {
+	for range queue {
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpillFiles(t *testing.T) {
+	fs := testFiles(20000, 100)
+	// more gaps: the files of one counter out of seven are missing.
+	var rest []*File
+	for _, f := range fs {
+		if f.Sequence%7 != 3 {
+			rest = append(rest, f)
+		}
+	}
+	want := countFiles(feedFiles(rest), 1, countOptions{})
+	got, err := spillFiles(feedFiles(rest), t.TempDir(), countOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d UPI, got %d", len(want), len(got))
+	}
+	for k, w := range want {
+		g, ok := got[k]
+		if !ok {
+			t.Errorf("%s: not found", k)
+			continue
+		}
+		if g.Count != w.Count || g.Uniq != w.Uniq || g.Invalid != w.Invalid || g.Size != w.Size || g.First != w.First || g.Last != w.Last {
+			t.Errorf("%s: want %+v, got %+v", k, *w, *g)
+		}
+		if g.Missing() != w.Missing() {
+			t.Errorf("%s: want %d missing, got %d", k, w.Missing(), g.Missing())
+		}
+		if g.Total() != w.Total() {
+			t.Errorf("%s: want total %d, got %d", k, w.Total(), g.Total())
+		}
+		if g.Completeness() != w.Completeness() {
+			t.Errorf("%s: want completeness %f, got %f", k, w.Completeness(), g.Completeness())
+		}
+		if gr, wr := g.LongestRun(), w.LongestRun(); !reflect.DeepEqual(gr, wr) {
+			t.Errorf("%s: want longest run %s, got %s", k, wr, gr)
+		}
+		gf, gl := g.Range()
+		if wf, wl := w.Range(); gf != wf || gl != wl {
+			t.Errorf("%s: want range %d-%d, got %d-%d", k, wf, wl, gf, gl)
+		}
+		if w.Uniq > 0 && len(w.MissingRanges()) == 0 {
+			t.Errorf("%s: expected missing ranges", k)
+		}
+		if g.Ranges() != nil || g.MissingRanges() != nil {
+			t.Errorf("%s: ranges given once compacted", k)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/midbel/cli"
+)
+
+var statsCommand = &cli.Command{
+	Usage: "stats [-d] [-s] [-e] [-year] [-doy] [-u] [-p] [-buffer] [-pprof] [-seq64] <archive,...>",
+	Alias: []string{"profile"},
+	Short: "profile the time spent to find, parse and count the files of the archive",
+	Run:   runStats,
+	Desc: `"stats" (profile) traverse the Hadock archive like "walk" and print, instead of
+the count of files, where the time has been spent.
+
+The period of time is selected with the same rules as the "walk" command.
+
+Options:
+
+  -u UPI     only count files for the given UPI
+  -buffer N  number of files found in advance of their processing (default 1024)
+  -p N       number of paths walked at the same time (default 1)
+  -s START   only count files created after START
+  -e END     only count files created before END
+  -d DAYS    only count files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only count files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
+  -pprof ADDR  serve the profiles of net/http/pprof under /debug/pprof/ on ADDR
+             (eg localhost:6060) while the archive is walked
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)
+
+Timings:
+
+  walk       time spent in filepath.Walk, including the parsing of the
+             filenames and the waits to send the files found
+  parse      time spent in parsing the filenames
+  ranges     time spent in tracking the sequence counters of the files
+  send wait  time spent by the walk waiting for the files to be counted
+  recv wait  time spent by the count waiting for the files to be found
+
+The timings are summed over the paths walked at the same time (see -p) and can
+then be greater than the elapsed time.`,
+}
+
+func runStats(cmd *cli.Command, args []string) error {
+	var start, end When
+	cmd.Flag.Var(&start, "s", "start")
+	cmd.Flag.Var(&end, "e", "end")
+	upi := cmd.Flag.String("u", "", "upi")
+	buffer := cmd.Flag.Int("buffer", DefaultBuffer, "buffer")
+	parallel := cmd.Flag.Int("p", 1, "parallel")
+	period := cmd.Flag.Int("d", 0, "period")
+	year := cmd.Flag.Int("year", 0, "year")
+	var days DayList
+	cmd.Flag.Var(&days, "doy", "days of year")
+	addr := cmd.Flag.String("pprof", "", "pprof address")
+	long := cmd.Flag.Bool("seq64", false, "64 bits sequence")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+
+	if cmd.Flag.NArg() == 0 {
+		cmd.Help()
+	}
+	if *addr != "" {
+		go servePprof(*addr)
+	}
+
+	paths, err := selectPaths(cmd.Flag.Args(), *period, start.Time, end.Time, *year, days)
+	if err != nil {
+		return err
+	}
+	p, upis := profileFiles(paths, scanOptions{
+		UPI:      *upi,
+		Parallel: *parallel,
+		Buffer:   *buffer,
+		Parse:    parseOptions{Long: *long},
+	})
+	p.Print(os.Stdout, upis)
+	return nil
+}
+
+// servePprof serves the profiles of net/http/pprof under /debug/pprof/ on addr
+// with a mux of its own: http.DefaultServeMux is never served.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Fprintf(os.Stderr, "pprof: %s\n", err)
+	}
+}
+
+// profileFiles walks paths and counts the files found like walk while their
+// walk is profiled. It gives the profile and the number of UPI found.
+func profileFiles(paths []string, opts scanOptions) (*scanProfile, int) {
+	p := newScanProfile()
+	opts.Profile = p
+
+	rs := countFiles(p.receive(walkFiles(paths, opts)), 1, countOptions{Profile: p})
+	return p, len(rs)
+}
+
+// scanProfile gives the cumulated time (in nanoseconds) spent by the steps of
+// a walk. The counters are updated atomically since the paths can be walked
+// concurrently.
+type scanProfile struct {
+	Files  uint64
+	Walk   int64
+	Parse  int64
+	Ranges int64
+	Send   int64
+	Recv   int64
+	Starts time.Time
+}
+
+func newScanProfile() *scanProfile {
+	return &scanProfile{Starts: time.Now()}
+}
+
+func (p *scanProfile) walking(t time.Time) {
+	atomic.AddInt64(&p.Walk, int64(time.Since(t)))
+}
+
+func (p *scanProfile) parsing(t time.Time) {
+	atomic.AddInt64(&p.Parse, int64(time.Since(t)))
+}
+
+func (p *scanProfile) tracking(t time.Time) {
+	atomic.AddInt64(&p.Ranges, int64(time.Since(t)))
+}
+
+// receive forwards the files of queue and records the time spent waiting for
+// them.
+func (p *scanProfile) receive(queue <-chan *File) <-chan *File {
+	q := make(chan *File)
+	go func() {
+		defer close(q)
+		for {
+			t := time.Now()
+			f, ok := <-queue
+			atomic.AddInt64(&p.Recv, int64(time.Since(t)))
+			if !ok {
+				return
+			}
+			atomic.AddUint64(&p.Files, 1)
+			q <- f
+		}
+	}()
+	return q
+}
+
+func (p *scanProfile) Print(w io.Writer, upis int) {
+	elapsed := time.Since(p.Starts)
+	fmt.Fprintf(w, "files: %d\n", atomic.LoadUint64(&p.Files))
+	fmt.Fprintf(w, "upis: %d\n", upis)
+	fmt.Fprintf(w, "elapsed: %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "walk: %s\n", loadDuration(&p.Walk))
+	fmt.Fprintf(w, "parse: %s\n", loadDuration(&p.Parse))
+	fmt.Fprintf(w, "ranges: %s\n", loadDuration(&p.Ranges))
+	fmt.Fprintf(w, "send wait: %s\n", loadDuration(&p.Send))
+	fmt.Fprintf(w, "recv wait: %s\n", loadDuration(&p.Recv))
+	if secs := elapsed.Seconds(); secs > 0 {
+		fmt.Fprintf(w, "throughput: %.2f files/s\n", float64(p.Files)/secs)
+	}
+}
+
+func loadDuration(v *int64) time.Duration {
+	return time.Duration(atomic.LoadInt64(v)).Round(time.Microsecond)
+}
+
+// sendFile sends f to queue and records the time spent waiting in p when a
+// walk is profiled.
+func sendFile(queue chan<- *File, f *File, p *scanProfile) {
+	if p == nil {
+		queue <- f
+		return
+	}
+	t := time.Now()
+	queue <- f
+	atomic.AddInt64(&p.Send, int64(time.Since(t)))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProfileFiles(t *testing.T) {
+	dir := t.TempDir()
+	testArchive(t, dir, append(testNames("AAA", 200), testNames("BBB", 100)...)...)
+
+	starts := time.Now()
+	p, upis := profileFiles([]string{filepath.Join(dir, "38")}, scanOptions{Parallel: 1})
+	elapsed := time.Since(starts)
+
+	if p.Files != 300 || upis != 2 {
+		t.Errorf("want 300 files and 2 UPI, got %d files and %d UPI", p.Files, upis)
+	}
+	for _, v := range []struct {
+		Name  string
+		Value int64
+	}{
+		{"walk", p.Walk},
+		{"parse", p.Parse},
+		{"ranges", p.Ranges},
+		{"recv", p.Recv},
+	} {
+		if v.Value <= 0 {
+			t.Errorf("%s: no time recorded", v.Name)
+		}
+		if time.Duration(v.Value) > elapsed {
+			t.Errorf("%s: %s recorded, more than the %s elapsed", v.Name, time.Duration(v.Value), elapsed)
+		}
+	}
+	// the filenames are parsed and the files sent during the walk.
+	if p.Parse+p.Send > p.Walk {
+		t.Errorf("parse (%s) and send (%s) longer than walk (%s)", time.Duration(p.Parse), time.Duration(p.Send), time.Duration(p.Walk))
+	}
+}
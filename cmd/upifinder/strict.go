@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// rejectList records the files that can not be parsed or that are discarded
+// instead of skipping them silently (see -strict). A nil rejectList records
+// nothing.
+type rejectList struct {
+	sync.Mutex
+	files []string
+}
+
+func (r *rejectList) reject(p string, err error) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.files = append(r.files, fmt.Sprintf("%s: %s", p, err))
+}
+
+// check writes to w the files rejected and returns an error if there is at
+// least one of them.
+func (r *rejectList) check(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if len(r.files) == 0 {
+		return nil
+	}
+	for _, f := range r.files {
+		fmt.Fprintln(w, f)
+	}
+	return fmt.Errorf("%d file(s) rejected", len(r.files))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	dir := t.TempDir()
+	testArchive(t, dir, append(testNames("XYZ", 3), "0038_XYZ_1_x_20190227_101010_00.dat")...)
+	roots := []string{filepath.Join(dir, "38")}
+
+	for _, s := range []bool{false, true} {
+		var rejected *rejectList
+		if s {
+			rejected = new(rejectList)
+		}
+		rs := countFiles(walkFiles(roots, scanOptions{Parallel: 1, Rejected: rejected}), 1, countOptions{})
+		if c := rs["38/XYZ"]; c == nil || c.Count != 3 {
+			t.Errorf("strict %t: want 3 files counted", s)
+		}
+		err := rejected.check(ioutil.Discard)
+		switch {
+		case s && err == nil:
+			t.Errorf("strict: expected error")
+		case s && len(rejected.files) != 1:
+			t.Errorf("strict: want 1 file rejected, got %d", len(rejected.files))
+		case !s && err != nil:
+			t.Errorf("not strict: unexpected error: %s", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/midbel/linewriter"
+)
+
+// Outage is a period of time during which at least a given number of UPI have
+// a gap at the same time (eg a problem of the ground segment instead of a
+// problem of one instrument).
+type Outage struct {
+	Starts time.Time `json:"dtstart"`
+	Ends   time.Time `json:"dtend"`
+	// Max is the highest number of UPI with a gap at the same time during
+	// the outage.
+	Max int `json:"max"`
+	// UPIs are the UPI with a gap during the outage.
+	UPIs []string `json:"upis"`
+}
+
+func (o *Outage) Duration() time.Duration {
+	return o.Ends.Sub(o.Starts)
+}
+
+// timelineGaps puts the gaps of gs on one time axis and gives the periods of
+// time during which at least n UPI have a gap at the same time. The gaps
+// without missing files (eg the start of a run) are ignored.
+func timelineGaps(gs []*Gap, n int) []*Outage {
+	type event struct {
+		When  time.Time
+		UPI   string
+		Delta int
+	}
+	var es []event
+	for _, g := range gs {
+		if g.absent || g.Count() == 0 || !g.Ends.After(g.Starts) {
+			continue
+		}
+		es = append(es, event{When: g.Starts, UPI: g.UPI, Delta: 1})
+		es = append(es, event{When: g.Ends, UPI: g.UPI, Delta: -1})
+	}
+	// gaps that only touch each other are not concurrent: at the same time,
+	// the ends of gaps come first.
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].When.Equal(es[j].When) {
+			return es[i].Delta < es[j].Delta
+		}
+		return es[i].When.Before(es[j].When)
+	})
+
+	var (
+		rs     []*Outage
+		curr   *Outage
+		seen   map[string]struct{}
+		active = make(map[string]int)
+	)
+	for _, e := range es {
+		active[e.UPI] += e.Delta
+		if active[e.UPI] <= 0 {
+			delete(active, e.UPI)
+		}
+		switch c := len(active); {
+		case c >= n && curr == nil:
+			curr = &Outage{Starts: e.When, Max: c}
+			seen = make(map[string]struct{})
+			for u := range active {
+				seen[u] = struct{}{}
+			}
+		case c >= n:
+			if c > curr.Max {
+				curr.Max = c
+			}
+			seen[e.UPI] = struct{}{}
+		case curr != nil:
+			curr.Ends = e.When
+			for u := range seen {
+				curr.UPIs = append(curr.UPIs, u)
+			}
+			sort.Strings(curr.UPIs)
+			if curr.Ends.After(curr.Starts) {
+				rs = append(rs, curr)
+			}
+			curr = nil
+		}
+	}
+	return rs
+}
+
+func reportTimelineJSON(w io.Writer, vs []*Outage, j jsonProfile) error {
+	e := json.NewEncoder(w)
+	for _, o := range vs {
+		if err := e.Encode(withProfile(o, j)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reportTimelineResults(w io.Writer, vs []*Outage, csv bool, zone *time.Location) {
+	line := Line(csv)
+	for _, o := range vs {
+		line.AppendTime(Local(o.Starts, zone), time.RFC3339, linewriter.AlignRight)
+		line.AppendTime(Local(o.Ends, zone), time.RFC3339, linewriter.AlignRight)
+		if elapsed := o.Duration(); csv {
+			line.AppendUint(uint64(elapsed.Seconds()), 10, linewriter.AlignRight)
+		} else {
+			line.AppendDuration(elapsed, 10, linewriter.AlignRight)
+		}
+		line.AppendUint(uint64(o.Max), 4, linewriter.AlignRight)
+		line.AppendUint(uint64(len(o.UPIs)), 4, linewriter.AlignRight)
+
+		upis := make([]string, len(o.UPIs))
+		for i, u := range o.UPIs {
+			upis[i] = Transform(u)
+		}
+		line.AppendString(strings.Join(upis, ","), 0, linewriter.AlignLeft)
+
+		io.Copy(w, line)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimelineGaps(t *testing.T) {
+	gap := func(upi string, starts, ends int) *Gap {
+		return &Gap{
+			UPI:    "38/" + upi,
+			Before: 1,
+			After:  10,
+			Starts: testEpoch.Add(time.Duration(starts) * time.Minute),
+			Ends:   testEpoch.Add(time.Duration(ends) * time.Minute),
+		}
+	}
+	gs := []*Gap{
+		gap("AAA", 10, 40),
+		gap("BBB", 20, 50),
+		gap("DDD", 30, 35),
+		// alone or only touching another gap: not concurrent.
+		gap("CCC", 60, 70),
+		gap("EEE", 70, 80),
+		// a start of run has no missing file.
+		{UPI: "38/FFF", Before: 10, After: 1, Starts: testEpoch, Ends: testEpoch.Add(time.Hour), reset: true},
+	}
+	data := []struct {
+		Min  int
+		Want []Outage
+	}{
+		{
+			Min: 2,
+			Want: []Outage{
+				{Starts: gs[1].Starts, Ends: gs[0].Ends, Max: 3, UPIs: []string{"38/AAA", "38/BBB", "38/DDD"}},
+			},
+		},
+		{
+			Min: 3,
+			Want: []Outage{
+				{Starts: gs[2].Starts, Ends: gs[2].Ends, Max: 3, UPIs: []string{"38/AAA", "38/BBB", "38/DDD"}},
+			},
+		},
+		{Min: 4},
+	}
+	for _, d := range data {
+		vs := timelineGaps(gs, d.Min)
+		if len(vs) != len(d.Want) {
+			t.Errorf("%d: want %d outages, got %d", d.Min, len(d.Want), len(vs))
+			continue
+		}
+		for i, v := range vs {
+			if w := d.Want[i]; !v.Starts.Equal(w.Starts) || !v.Ends.Equal(w.Ends) || v.Max != w.Max || !reflect.DeepEqual(v.UPIs, w.UPIs) {
+				t.Errorf("%d: outage %d: want %+v, got %+v", d.Min, i, w, *v)
+			}
+		}
+	}
+}
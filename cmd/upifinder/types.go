@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,15 +33,55 @@ func (w *When) String() string {
 	return time.Now().Format(TimeFormat)
 }
 
+// DayList is a list of days of year given as a comma separated list. The flag
+// can be repeated.
+type DayList []int
+
+func (d *DayList) Set(v string) error {
+	for _, p := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 366 {
+			return fmt.Errorf("invalid day of year %d (1-366)", n)
+		}
+		*d = append(*d, n)
+	}
+	return nil
+}
+
+func (d *DayList) String() string {
+	return fmt.Sprint([]int(*d))
+}
+
 type Gap struct {
 	UPI    string    `json:"upi" xml:"upi"`
-	Before uint32    `json:"last" xml:"last"`
-	After  uint32    `json:"first" xml:"first"`
+	Before uint64    `json:"last" xml:"last"`
+	After  uint64    `json:"first" xml:"first"`
 	Starts time.Time `json:"dtstart" xml:"dtstart"`
 	Ends   time.Time `json:"dtend" xml:"dtend"`
-}
-
-func (g *Gap) Count() uint32 {
+	// Source is the source of the files around the gap.
+	Source string `json:"source,omitempty" xml:"source,omitempty"`
+	// Sources are the sources to which the gap is attributed when the files
+	// of all sources are checked together (see check -merge-sources).
+	Sources []string `json:"sources,omitempty" xml:"sources,omitempty"`
+
+	absent bool
+	// reset is set for the gap between the last file of a run and the first
+	// file of the next one (see check -reset).
+	reset bool
+	// slow is set for the gap between two consecutive files acquired later
+	// than the cadence expected for their UPI (see check -cadence).
+	slow bool
+	// run is the number of resets of the sequence counter before the gap.
+	run int
+}
+
+func (g *Gap) Count() uint64 {
+	if g.After <= g.Before {
+		return 0
+	}
 	return (g.After - g.Before) - 1
 }
 
@@ -45,16 +89,29 @@ func (g *Gap) Duration() time.Duration {
 	return g.Ends.Sub(g.Starts)
 }
 
+// Contains reports whether the sequence counter v is missing in g.
+func (g *Gap) Contains(v uint64) bool {
+	return g.Before < v && v < g.After
+}
+
+// Overlaps reports whether g and o have missing sequence counters in common.
+func (g *Gap) Overlaps(o *Gap) bool {
+	if g.Count() == 0 || o.Count() == 0 {
+		return false
+	}
+	return g.Before+1 < o.After && o.Before+1 < g.After
+}
+
 type Range struct {
-	First uint32
-	Last  uint32
+	First uint64
+	Last  uint64
 }
 
-func (r *Range) Total() uint32 {
+func (r *Range) Total() uint64 {
 	return r.Last - r.First
 }
 
-func (r *Range) Has(v uint32) bool {
+func (r *Range) Has(v uint64) bool {
 	return r.First <= v && r.Last >= v
 }
 
@@ -62,7 +119,7 @@ func (r *Range) String() string {
 	return fmt.Sprintf("[%d, %d]", r.First, r.Last)
 }
 
-func single(v uint32) *Range {
+func single(v uint64) *Range {
 	return &Range{v, v}
 }
 
@@ -70,74 +127,176 @@ type Coze struct {
 	UPI     string `json:"upi" xml:"upi"`
 	Count   uint64 `json:"total" xml:"total"`
 	Size    uint64 `json:"size" xml:"size"`
+	Stored  uint64 `json:"stored" xml:"stored"`
 	Invalid uint64 `json:"invalid" xml:"invalid"`
 	Uniq    uint64 `json:"uniq" xml:"uniq"`
 
 	Starts time.Time `json:"dtstart" xml:"dtstart"`
 	Ends   time.Time `json:"dtend" xml:"dtend"`
 
-	First uint32 `json:"first" xml:"first"`
-	Last  uint32 `json:"last" xml:"last"`
+	First uint64 `json:"first" xml:"first"`
+	Last  uint64 `json:"last" xml:"last"`
+
+	// Period is the day, week or month of the files when they are grouped by
+	// period (see walk -group).
+	Period string `json:"period,omitempty" xml:"period,omitempty"`
 
 	seen []*Range
+	// invalid are the sequence counters of the invalid files when they are
+	// kept (see countOptions). They are only used to not count these sequence
+	// counters as missing.
+	invalid []*Range
+	absent  bool
+	// missing is the number of missing sequence counters of the ranges released
+	// by compact.
+	missing uint64
+	// longest is the longest run of the ranges released by compact.
+	longest *Range
+	// total is the result of Total before the ranges are released by compact.
+	total uint64
+	// compacted is set once the ranges are released by compact.
+	compacted bool
+	// opts are the options of the count of the files (see countFiles).
+	opts countOptions
 }
 
 func (c *Coze) Update(f *File) {
 	c.Count++
 	// c.Size += uint64(f.Size)
-	if c.Starts.IsZero() || c.Starts.Equal(f.AcqTime) || c.Starts.After(f.AcqTime) {
+	// files acquired at the same time are ordered by their sequence counter so
+	// that First and Last do not depend on the order the files are found.
+	if c.Starts.IsZero() || c.Starts.After(f.AcqTime) || (c.Starts.Equal(f.AcqTime) && f.Sequence < c.First) {
 		c.Starts = f.AcqTime
 		c.First = f.Sequence
 	}
-	if c.Ends.IsZero() || c.Ends.Equal(f.AcqTime) || c.Ends.Before(f.AcqTime) {
+	if c.Ends.IsZero() || c.Ends.Before(f.AcqTime) || (c.Ends.Equal(f.AcqTime) && f.Sequence > c.Last) {
 		c.Ends = f.AcqTime
 		c.Last = f.Sequence
 	}
 
+	if c.opts.CountOnly {
+		if f.Valid() {
+			c.Size += uint64(f.Size)
+			c.Stored += uint64(f.Stored)
+		} else {
+			c.Invalid++
+		}
+		return
+	}
 	if f.Valid() {
 		if !c.Seen(f.Sequence) {
 			c.Uniq++
 			c.Size += uint64(f.Size)
+			c.Stored += uint64(f.Stored)
 		}
 	} else {
 		c.Invalid++
+		if c.opts.KeepInvalid {
+			if s, ok := c.track(c.invalid, f.Sequence); !ok {
+				c.invalid = s
+			}
+		}
 	}
 }
 
-func (c *Coze) Seen(v uint32) bool {
-	s, ok := inRanges(c.seen, v)
+func (c *Coze) Seen(v uint64) bool {
+	s, ok := c.track(c.seen, v)
 	if !ok {
 		c.seen = s
 	}
 	return ok
 }
 
+// track is inRanges with the time spent recorded when the count is profiled.
+func (c *Coze) track(seen []*Range, v uint64) ([]*Range, bool) {
+	if p := c.opts.Profile; p != nil {
+		defer p.tracking(time.Now())
+	}
+	return inRanges(seen, v)
+}
+
+// Ranges gives the ranges of sequence counters seen by c. It is nil once the
+// ranges are released by compact.
 func (c Coze) Ranges() []*Range {
+	if c.compacted {
+		return nil
+	}
 	return c.seen
 }
 
+// MissingRanges gives the ranges of missing sequence counters between the
+// ranges seen by c. A missing range is given by the sequence counters found
+// around it, including the ones of the invalid files when they are kept. It is
+// nil once the ranges are released by compact.
 func (c Coze) MissingRanges() []*Range {
 	n := len(c.seen)
-	if n == 0 {
+	if n == 0 || c.compacted {
 		return nil
 	}
 	var rs []*Range
 	for i := 1; i < n; i++ {
-		r := Range{First: c.seen[i-1].Last, Last: c.seen[i].First}
-		rs = append(rs, &r)
+		rs = splitMissing(rs, c.seen[i-1].Last, c.seen[i].First, c.invalid)
+	}
+	return rs
+}
+
+// splitMissing appends to rs the ranges of sequence counters between lo and hi
+// (excluded) that are not in the ranges of skip.
+func splitMissing(rs []*Range, lo, hi uint64, skip []*Range) []*Range {
+	ix := sort.Search(len(skip), func(i int) bool {
+		return skip[i].Last > lo
+	})
+	cur := lo
+	for _, s := range skip[ix:] {
+		if s.First >= hi {
+			break
+		}
+		first, last := s.First, s.Last
+		if first <= lo {
+			first = lo + 1
+		}
+		if last >= hi {
+			last = hi - 1
+		}
+		if first > cur+1 {
+			rs = append(rs, &Range{First: cur, Last: first})
+		}
+		cur = last
+	}
+	if hi > cur+1 {
+		rs = append(rs, &Range{First: cur, Last: hi})
 	}
 	return rs
 }
 
-func (c Coze) Total() uint32 {
-	var t uint32
+// LongestRun gives the widest range of consecutive sequence counters seen by c.
+// The first one is given when several ranges have the same width. It is nil
+// when there is no file.
+func (c Coze) LongestRun() *Range {
+	if c.longest != nil {
+		return c.longest
+	}
+	var r *Range
+	for _, s := range c.seen {
+		if r == nil || s.Total() > r.Total() {
+			r = s
+		}
+	}
+	return r
+}
+
+func (c Coze) Total() uint64 {
+	if c.compacted {
+		return c.total
+	}
+	var t uint64
 	for _, r := range c.seen {
 		t += r.Total()
 	}
 	return t + 1
 }
 
-func (c Coze) Range() (uint32, uint32) {
+func (c Coze) Range() (uint64, uint64) {
 	n := len(c.seen)
 	if n == 0 {
 		return 0, 0
@@ -146,22 +305,79 @@ func (c Coze) Range() (uint32, uint32) {
 	return first.First, last.Last
 }
 
+// Completeness gives the ratio between the number of uniq files and the number
+// of sequence counters between the first and the last files. It is zero when
+// there is no file.
+func (c Coze) Completeness() float64 {
+	if len(c.seen) == 0 || c.Uniq == 0 {
+		return 0
+	}
+	first, last := c.Range()
+	r := float64(c.Uniq) / (float64(last-first) + 1)
+	if r > 1 {
+		r = 1
+	}
+	return r
+}
+
 func (c Coze) Missing() uint64 {
 	if len(c.seen) == 0 {
 		return 0
 	}
-	var m uint64
+	m := c.missing
+	if len(c.invalid) > 0 {
+		for _, r := range c.MissingRanges() {
+			m += r.Last - r.First - 1
+		}
+		return m
+	}
 	for i := 1; i < len(c.seen); i++ {
 		d := c.seen[i].First - c.seen[i-1].Last
-		m += uint64(d - 1)
+		m += d - 1
 	}
 	return m
 }
 
+// compact replaces the ranges of sequence counters seen by c by one range from
+// its first to its last sequence counter once all its files are counted. The
+// number of missing files, the first and last sequence counters, the longest
+// run, the total and the completeness are kept but not the ranges themselves:
+// Ranges and MissingRanges are then nil. c must not be updated afterwards.
+func (c *Coze) compact() {
+	n := len(c.seen)
+	if n <= 1 {
+		return
+	}
+	c.missing = c.Missing()
+	c.longest = c.LongestRun()
+	c.total = c.Total()
+	c.seen = []*Range{{First: c.seen[0].First, Last: c.seen[n-1].Last}}
+	c.invalid = nil
+	c.compacted = true
+}
+
+// Age gives the time elapsed between the acquisition of the most recent file
+// and now. It is zero when there is no file.
+func (c Coze) Age(now time.Time) time.Duration {
+	if c.Ends.IsZero() || now.Before(c.Ends) {
+		return 0
+	}
+	return now.Sub(c.Ends)
+}
+
 func (c Coze) Duration() time.Duration {
 	return c.Ends.Sub(c.Starts)
 }
 
+// Compression gives the ratio between the size of the files as stored in the
+// archives and their uncompressed size.
+func (c Coze) Compression() float64 {
+	if c.Size == 0 {
+		return 0
+	}
+	return float64(c.Stored) / float64(c.Size)
+}
+
 func (c Coze) Corrupted() float64 {
 	if c.Count == 0 || c.Invalid == 0 {
 		return 0
@@ -184,9 +400,17 @@ type File struct {
 	Source   string    `json:"source" xml:"source"`
 	Info     string    `json:"upi" xml:"upi"`
 	Size     int64     `json:"size" xml:"size"`
-	Sequence uint32    `json:"sequence" xml:"sequence"`
+	Stored   int64     `json:"stored" xml:"stored"`
+	Sequence uint64    `json:"sequence" xml:"sequence"`
 	AcqTime  time.Time `json:"dtstamp" xml:"dtstamp"`
-	RecTime  time.Time `json:"-" xml:"-"`
+	RecTime  time.Time `json:"rectime" xml:"rectime"`
+	Digest   string    `json:"digest,omitempty" xml:"digest,omitempty"`
+	Meta     *Metadata `json:"metadata,omitempty" xml:"metadata,omitempty"`
+	// Provenance is the kind of location where the file has been found: loose
+	// (filesystem), lst, tar or zip.
+	Provenance string `json:"provenance,omitempty" xml:"provenance,omitempty"`
+
+	typ string
 }
 
 func (f *File) Compare(p *File) *Gap {
@@ -198,6 +422,7 @@ func (f *File) Compare(p *File) *Gap {
 	}
 	g := Gap{
 		UPI:    p.String(),
+		Source: gapSource(p, f),
 		Starts: p.AcqTime,
 		Ends:   f.AcqTime,
 		Before: p.Sequence,
@@ -206,6 +431,15 @@ func (f *File) Compare(p *File) *Gap {
 	return &g
 }
 
+// gapSource gives the source of the gap between the files p and f: their source
+// or both sources joined by a + when they differ.
+func gapSource(p, f *File) string {
+	if p.Source == f.Source {
+		return p.Source
+	}
+	return p.Source + "+" + f.Source
+}
+
 func (f *File) Name() string {
 	ps := strings.Split(filepath.Base(f.Path), "_")
 	return strings.Join(ps[:len(ps)-3], "_")
@@ -219,54 +453,224 @@ func (f *File) String() string {
 	return fmt.Sprintf("%s/%s", f.Source, f.Info)
 }
 
-func parseFilename(p, upi string, i int64) (*File, error) {
+// Reasons for which parseName discards a file. These errors are not reported
+// by parseFilename: the file is silently skipped.
+var (
+	ErrName    = errors.New("unsupported characters in filename")
+	ErrPattern = errors.New("filename does not match pattern")
+	ErrType    = errors.New("unknown type")
+	ErrOrigin  = errors.New("origin not accepted for type")
+	ErrSource  = errors.New("source is not hexadecimal")
+	ErrFields  = errors.New("not enough fields")
+)
+
+// Reasons for which parseName fails to parse a filename.
+var (
+	ErrSequence = errors.New("sequence")
+	ErrTime     = errors.New("acqtime")
+)
+
+func isDiscarded(err error) bool {
+	return errors.Is(err, ErrName) || errors.Is(err, ErrPattern) || errors.Is(err, ErrType) || errors.Is(err, ErrOrigin) || errors.Is(err, ErrSource) || errors.Is(err, ErrFields)
+}
+
+// parseFilename parses the filename of p with the options of opts. The files
+// discarded are recorded (see scanOptions) and skipped: nil is returned
+// without error.
+func parseFilename(p string, i int64, opts scanOptions) (*File, error) {
+	if opts.Profile != nil {
+		defer opts.Profile.parsing(time.Now())
+	}
+	f, err := parseName(p, opts.UPI, i, opts.Parse)
+	if err == nil {
+		return f, nil
+	}
+	opts.Rejected.reject(p, err)
+	opts.Drops.drop(p, err)
+	switch {
+	case errors.Is(err, ErrType):
+		unknownType(f.typ)
+	case errors.Is(err, ErrSource):
+		warnOnce(fmt.Sprintf("files with source %q are discarded (not hexadecimal)", f.Source))
+	}
+	if isDiscarded(err) {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// parseName parses the filename of p and gives the reason why the file is
+// rejected if any. The returned File is partially filled when an error
+// occurs after the fields of the filename have been read.
+func parseName(p, upi string, i int64, opts parseOptions) (*File, error) {
 	// if !utf8.ValidString(p) {
 	// 	return nil, nil
 	// }
 	if !Keep(filepath.Base(p)) {
-		return nil, nil
+		return nil, ErrName
+	}
+	if opts.Pattern != nil {
+		return parsePattern(p, upi, i, opts)
 	}
 	ps := strings.Split(filepath.Base(p), "_")
+	if len(ps) < 6 {
+		return nil, fmt.Errorf("%w (%d)", ErrFields, len(ps))
+	}
 
 	f := File{
 		Path:   p,
 		Source: strings.TrimLeft(ps[0], "0"),
 		Size:   i,
-	}
-	if s, err := strconv.ParseInt(f.Source, 16, 64); err != nil {
-		return nil, err
-	} else {
-		var origins []int
-		switch ps[len(ps)-5] {
-		case "1", "2":
-			origins = OriImages
-		case "3":
-			origins = OriSciences
-		default:
-		}
-		if !acceptOrigin(int(s), origins) {
-			return nil, nil
-		}
+		Stored: i,
+		typ:    ps[len(ps)-5],
 	}
 	if len(upi) == 0 {
-		f.Info = strings.Join(ps[1:len(ps)-5], "_")
+		first, last, err := opts.fields().Bounds(len(ps))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrFields, err)
+		}
+		f.Info = strings.Join(ps[first:last], "_")
 	} else {
 		f.Info = upi
 	}
-	if n, err := strconv.ParseUint(ps[len(ps)-4], 10, 32); err == nil {
-		f.Sequence = uint32(n)
+	// a file discarded because of its origin is still parsed to give all its
+	// information to the caller but the origin takes precedence over other
+	// errors.
+	discard := f.checkOrigin(opts.origins())
+	if n, err := parseSequence(ps[len(ps)-4], opts.Long); err == nil {
+		f.Sequence = n
 	} else {
-		return nil, err
+		return &f, firstError(discard, fmt.Errorf("%w: %s", ErrSequence, err))
 	}
 
 	if t, err := time.Parse("20060102150405", ps[len(ps)-3]+ps[len(ps)-2]); err == nil {
-		d, _ := strconv.ParseInt(strings.TrimLeft(ps[0], "0"), 10, 64)
-		f.RecTime = t.Add(time.Duration(d) * time.Minute)
+		f.RecTime = t.Add(opts.delta()(ps))
 		f.AcqTime = t
 	} else {
-		return nil, err
+		return &f, firstError(discard, fmt.Errorf("%w: %s", ErrTime, err))
+	}
+	return &f, discard
+}
+
+// parseSequence parses the sequence counter v encoded on 32 bits or, when long
+// is set, on 64 bits (see -seq64).
+func parseSequence(v string, long bool) (uint64, error) {
+	bits := 32
+	if long {
+		bits = 64
+	}
+	return strconv.ParseUint(v, 10, bits)
+}
+
+func firstError(es ...error) error {
+	for _, e := range es {
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// checkOrigin checks that the source of f is an origin accepted for its type
+// by origins. The type is not checked if f has none.
+func (f *File) checkOrigin(origins Origins) error {
+	s, err := strconv.ParseInt(f.Source, 16, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSource, err)
+	}
+	if f.typ == "" {
+		return nil
+	}
+	accepted, ok := origins[f.typ]
+	if !ok {
+		return ErrType
+	}
+	if !acceptOrigin(int(s), accepted) {
+		return ErrOrigin
+	}
+	return nil
+}
+
+// FieldRange selects the fields of a filename (separated by underscores) that
+// compose the UPI. Negative values count from the number of fields like Last
+// in the default range (1:-5): the UPI is made of all fields between the
+// source and the five trailing fields (type, sequence, date, time, suffix).
+type FieldRange struct {
+	First int
+	Last  int
+}
+
+// DefaultFields is the range of the fields of the UPI used when none is given.
+var DefaultFields = FieldRange{First: 1, Last: -5}
+
+// Set parses a range given as FIRST:LAST.
+func (r *FieldRange) Set(v string) error {
+	ps := strings.SplitN(v, ":", 2)
+	if len(ps) != 2 {
+		return fmt.Errorf("invalid field range %s (FIRST:LAST)", v)
+	}
+	first, err := strconv.Atoi(ps[0])
+	if err != nil {
+		return err
+	}
+	last, err := strconv.Atoi(ps[1])
+	if err != nil {
+		return err
+	}
+	r.First, r.Last = first, last
+	return nil
+}
+
+func (r *FieldRange) String() string {
+	return fmt.Sprintf("%d:%d", r.First, r.Last)
+}
+
+// Bounds gives the indices of the first and last (excluded) fields of the UPI
+// for a filename of n fields. The UPI can not include the source nor the five
+// trailing fields.
+func (r FieldRange) Bounds(n int) (int, int, error) {
+	first, last := r.First, r.Last
+	if first < 0 {
+		first += n
+	}
+	if last < 0 {
+		last += n
+	}
+	if first < 1 || first > last || last > n-5 {
+		return 0, 0, fmt.Errorf("upi fields %s out of range (%d fields)", r.String(), n)
+	}
+	return first, last, nil
+}
+
+// DeltaFunc gives the elapsed time between the acquisition and the reception
+// of a file from the underscore separated fields of its name.
+type DeltaFunc func([]string) time.Duration
+
+// deltaFromSource reads the delta, in minutes, from the first field of the
+// filename (the source).
+func deltaFromSource(ps []string) time.Duration {
+	d, _ := strconv.ParseInt(strings.TrimLeft(ps[0], "0"), 10, 64)
+	return time.Duration(d) * time.Minute
+}
+
+// deltaFromSuffix reads the delta, in minutes, from the last field of the
+// filename (the one following the acquisition time), without its extension.
+func deltaFromSuffix(ps []string) time.Duration {
+	n := ps[len(ps)-1]
+	n = strings.TrimSuffix(n, filepath.Ext(n))
+	d, _ := strconv.ParseInt(strings.TrimLeft(n, "0"), 10, 64)
+	return time.Duration(d) * time.Minute
+}
+
+func parseDelta(v string) (DeltaFunc, error) {
+	switch strings.ToLower(v) {
+	case "source", "":
+		return deltaFromSource, nil
+	case "suffix":
+		return deltaFromSuffix, nil
+	default:
+		return nil, fmt.Errorf("unsupported delta %s", v)
 	}
-	return &f, nil
 }
 
 var (
@@ -274,6 +678,128 @@ var (
 	OriSciences = []int{0x35, 0x36, 0x39, 0x40, 0x41, 0x51, 0x90}
 )
 
+// Origins maps the type field of the filenames to the list of origins (source)
+// accepted for this type.
+type Origins map[string][]int
+
+// Set adds a mapping given as TYPE=ORIGINS where ORIGINS is images, sciences
+// or a comma separated list of hexadecimal origins.
+func (o Origins) Set(v string) error {
+	ps := strings.SplitN(v, "=", 2)
+	if len(ps) != 2 || ps[0] == "" {
+		return fmt.Errorf("invalid origins %s", v)
+	}
+	var vs []int
+	switch strings.ToLower(ps[1]) {
+	case "images":
+		vs = OriImages
+	case "sciences":
+		vs = OriSciences
+	default:
+		for _, p := range strings.Split(ps[1], ",") {
+			n, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(p), "0x"), 16, 64)
+			if err != nil {
+				return err
+			}
+			vs = append(vs, int(n))
+		}
+		sort.Ints(vs)
+	}
+	o[ps[0]] = vs
+	return nil
+}
+
+func (o Origins) String() string {
+	return fmt.Sprint(map[string][]int(o))
+}
+
+// DefaultOrigins are the origins accepted by type when none is given.
+var DefaultOrigins = Origins{
+	"1": OriImages,
+	"2": OriImages,
+	"3": OriSciences,
+}
+
+// parseOptions holds the options used to parse the filenames. The zero value
+// parses the filenames with the defaults of each option.
+type parseOptions struct {
+	// Pattern, when set, replaces the parsing of the fields separated by
+	// underscores (see -pattern).
+	Pattern *regexp.Regexp
+	// Fields are the fields of the UPI (see -upi-fields). The zero value
+	// selects DefaultFields.
+	Fields FieldRange
+	// Origins are the origins accepted by type (see -origin). DefaultOrigins
+	// are used when nil.
+	Origins Origins
+	// Long accepts the sequence counters encoded on 64 bits instead of 32 bits
+	// (see -seq64).
+	Long bool
+	// Delta computes the reception time (see -delta). deltaFromSource is used
+	// when nil.
+	Delta DeltaFunc
+}
+
+// newParseOptions gives the default options with a copy of DefaultOrigins to
+// which the origins of -origin can be added.
+func newParseOptions() parseOptions {
+	origins := make(Origins)
+	for t, vs := range DefaultOrigins {
+		origins[t] = vs
+	}
+	return parseOptions{
+		Fields:  DefaultFields,
+		Origins: origins,
+		Delta:   deltaFromSource,
+	}
+}
+
+func (o parseOptions) fields() FieldRange {
+	if o.Fields == (FieldRange{}) {
+		return DefaultFields
+	}
+	return o.Fields
+}
+
+func (o parseOptions) origins() Origins {
+	if o.Origins == nil {
+		return DefaultOrigins
+	}
+	return o.Origins
+}
+
+func (o parseOptions) delta() DeltaFunc {
+	if o.Delta == nil {
+		return deltaFromSource
+	}
+	return o.Delta
+}
+
+var warnings struct {
+	sync.Mutex
+	seen map[string]struct{}
+}
+
+// warnOnce prints msg on stderr the first time it is given.
+func warnOnce(msg string) {
+	warnings.Lock()
+	defer warnings.Unlock()
+	if _, ok := warnings.seen[msg]; ok {
+		return
+	}
+	if warnings.seen == nil {
+		warnings.seen = make(map[string]struct{})
+	}
+	warnings.seen[msg] = struct{}{}
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// unknownType prints a message the first time a file with the type t is
+// discarded.
+func unknownType(t string) {
+	warnOnce(fmt.Sprintf("files with unknown type %q are discarded (see -origin)", t))
+}
+
 func acceptOrigin(o int, origins []int) bool {
 	if len(origins) == 0 {
 		return false
@@ -354,12 +880,22 @@ func parseTime(s string) (time.Time, error) {
 	return t, fmt.Errorf("no suitable format found for %q", s)
 }
 
-func inRanges(seen []*Range, v uint32) ([]*Range, bool) {
+func inRanges(seen []*Range, v uint64) ([]*Range, bool) {
 	n := len(seen)
 	if n == 0 {
 		seen = append(seen, single(v))
 		return seen, false
 	}
+	// fast path: files are most of the time found in the order of their
+	// sequence counter.
+	if last := seen[n-1]; v > last.Last {
+		if v-last.Last == 1 {
+			last.Last = v
+		} else {
+			seen = append(seen, single(v))
+		}
+		return seen, false
+	}
 	ix := sort.Search(n, func(i int) bool {
 		return seen[i].Last >= v
 	})
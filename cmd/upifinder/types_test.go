@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCozeKeepInvalid(t *testing.T) {
+	data := []struct {
+		Seqs    []int
+		Keep    bool
+		Uniq    uint64
+		Invalid uint64
+		Missing uint64
+		Ranges  string
+	}{
+		// a sequence counter only found in an invalid file.
+		{Seqs: []int{1, -3, 5}, Keep: false, Uniq: 2, Invalid: 1, Missing: 3, Ranges: "[[1, 5]]"},
+		{Seqs: []int{1, -3, 5}, Keep: true, Uniq: 2, Invalid: 1, Missing: 2, Ranges: "[[1, 3] [3, 5]]"},
+		{Seqs: []int{1, -2, -3, -4, 5}, Keep: true, Uniq: 2, Invalid: 3, Missing: 0, Ranges: "[]"},
+		{Seqs: []int{1, -2, -6, 8}, Keep: true, Uniq: 2, Invalid: 2, Missing: 4, Ranges: "[[2, 6] [6, 8]]"},
+		// the valid copy is counted whatever the order the files are found.
+		{Seqs: []int{1, -2, 2, 3}, Keep: true, Uniq: 3, Invalid: 1, Missing: 0, Ranges: "[]"},
+		{Seqs: []int{1, 2, -2, 3}, Keep: true, Uniq: 3, Invalid: 1, Missing: 0, Ranges: "[]"},
+		{Seqs: []int{1, -2, 2, 3}, Keep: false, Uniq: 3, Invalid: 1, Missing: 0, Ranges: "[]"},
+	}
+	for _, d := range data {
+		c := testCozeWith(countOptions{KeepInvalid: d.Keep}, "XYZ", d.Seqs...)
+		if c.Uniq != d.Uniq || c.Invalid != d.Invalid || c.Missing() != d.Missing {
+			t.Errorf("%v (keep: %t): want uniq/invalid/missing %d/%d/%d, got %d/%d/%d", d.Seqs, d.Keep, d.Uniq, d.Invalid, d.Missing, c.Uniq, c.Invalid, c.Missing())
+		}
+		if want := d.Uniq * 100; c.Size != want {
+			t.Errorf("%v (keep: %t): want size %d, got %d", d.Seqs, d.Keep, want, c.Size)
+		}
+		if got := fmt.Sprint(c.MissingRanges()); got != d.Ranges {
+			t.Errorf("%v (keep: %t): want missing ranges %s, got %s", d.Seqs, d.Keep, d.Ranges, got)
+		}
+	}
+}
+
+func TestCozeCompleteness(t *testing.T) {
+	data := []struct {
+		Seqs []int
+		Want float64
+	}{
+		{Seqs: nil, Want: 0},
+		{Seqs: []int{7}, Want: 1},
+		{Seqs: []int{1, 2, 3, 4, 5}, Want: 1},
+		{Seqs: []int{1, 2, 4, 5, 6, 9, 10}, Want: 0.7},
+		{Seqs: []int{1, 1, 2, 2, 4}, Want: 0.75},
+		{Seqs: []int{-1, -2}, Want: 0},
+	}
+	for _, d := range data {
+		c := testCoze("XYZ", d.Seqs...)
+		if got := c.Completeness(); math.Abs(got-d.Want) > 1e-9 {
+			t.Errorf("%v: want %.2f, got %.2f", d.Seqs, d.Want, got)
+		}
+	}
+}
+
+func TestCozeCountOnly(t *testing.T) {
+	// testFiles gives one duplicate out of ten files (100 bytes each).
+	fs := testFiles(5000, 16)
+	full := countFiles(feedFiles(fs), 1, countOptions{})
+	fast := countFiles(feedFiles(fs), 1, countOptions{CountOnly: true})
+
+	if len(full) != len(fast) {
+		t.Fatalf("want %d UPI, got %d", len(full), len(fast))
+	}
+	for k, w := range full {
+		g := fast[k]
+		if g.Count != w.Count || g.Invalid != w.Invalid || g.First != w.First || g.Last != w.Last {
+			t.Errorf("%s: want count/invalid %d/%d, got %d/%d", k, w.Count, w.Invalid, g.Count, g.Invalid)
+		}
+		if g.Uniq != 0 || g.Missing() != 0 {
+			t.Errorf("%s: uniq and missing should not be computed", k)
+		}
+		// the size of the duplicated files is only counted by -count-only.
+		dups := w.Count - w.Invalid - w.Uniq
+		if want := w.Size + dups*100; g.Size != want {
+			t.Errorf("%s: want size %d (%d duplicates), got %d", k, want, dups, g.Size)
+		}
+	}
+}
+
+func BenchmarkCozeUpdate(b *testing.B) {
+	fs := testFiles(100000, 64)
+	for _, only := range []bool{false, true} {
+		b.Run(fmt.Sprintf("count-only-%t", only), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				cs := make(map[string]*Coze)
+				for _, f := range fs {
+					c, ok := cs[f.Info]
+					if !ok {
+						c = &Coze{UPI: f.Info, opts: countOptions{CountOnly: only}}
+						cs[f.Info] = c
+					}
+					c.Update(f)
+				}
+			}
+		})
+	}
+}
+
+func TestParseNameSeq64(t *testing.T) {
+	const seq = 1<<32 + 10
+	p := fmt.Sprintf("0038_XYZ_1_%d_20190227_101010_00.dat", uint64(seq))
+
+	if _, err := parseName(p, "", 0, parseOptions{}); !errors.Is(err, ErrSequence) {
+		t.Errorf("32 bits: want sequence error, got %v", err)
+	}
+	f, err := parseName(p, "", 0, parseOptions{Long: true})
+	if err != nil {
+		t.Fatalf("64 bits: unexpected error: %s", err)
+	}
+	if f.Sequence != seq {
+		t.Errorf("64 bits: want sequence %d, got %d", uint64(seq), f.Sequence)
+	}
+}
+
+func TestParseNameOrigins(t *testing.T) {
+	custom := Origins{"4": {0x38}}
+	data := []struct {
+		Name    string
+		Origins Origins
+		Err     error
+	}{
+		{Name: "0038_XYZ_1_10_20190227_101010_00.dat"},
+		{Name: "0035_XYZ_3_10_20190227_101010_00.dat"},
+		{Name: "0038_XYZ_3_10_20190227_101010_00.dat", Err: ErrOrigin},
+		{Name: "0035_XYZ_1_10_20190227_101010_00.dat", Err: ErrOrigin},
+		{Name: "0038_XYZ_4_10_20190227_101010_00.dat", Err: ErrType},
+		{Name: "zz38_XYZ_1_10_20190227_101010_00.dat", Err: ErrSource},
+		{Name: "0038_XYZ_4_10_20190227_101010_00.dat", Origins: custom},
+		{Name: "0038_XYZ_1_10_20190227_101010_00.dat", Origins: custom, Err: ErrType},
+		{Name: "0039_XYZ_4_10_20190227_101010_00.dat", Origins: custom, Err: ErrOrigin},
+		// the origin takes precedence over the other errors.
+		{Name: "0038_XYZ_3_ten_20190227_101010_00.dat", Err: ErrOrigin},
+		{Name: "0038_XYZ_1_ten_20190227_101010_00.dat", Err: ErrSequence},
+	}
+	for _, d := range data {
+		f, err := parseName(d.Name, "", 0, parseOptions{Origins: d.Origins})
+		if d.Err == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", d.Name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, d.Err) {
+			t.Errorf("%s: want %s, got %v", d.Name, d.Err, err)
+		}
+		if f == nil || f.Info != "XYZ" {
+			t.Errorf("%s: want file parsed with its upi, got %v", d.Name, f)
+		}
+	}
+}
+
+func TestParseNameDelta(t *testing.T) {
+	const name = "0038_XYZ_1_10_20190227_101010_05.dat"
+	acq := time.Date(2019, 2, 27, 10, 10, 10, 0, time.UTC)
+	data := []struct {
+		Delta string
+		Want  time.Duration
+	}{
+		{Delta: "", Want: 38 * time.Minute},
+		{Delta: "source", Want: 38 * time.Minute},
+		{Delta: "SUFFIX", Want: 5 * time.Minute},
+	}
+	for _, d := range data {
+		delta, err := parseDelta(d.Delta)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", d.Delta, err)
+		}
+		f, err := parseName(name, "", 0, parseOptions{Delta: delta})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", d.Delta, err)
+		}
+		if !f.AcqTime.Equal(acq) || f.RecTime.Sub(f.AcqTime) != d.Want {
+			t.Errorf("%s: want delta %s, got %s (acqtime %s)", d.Delta, d.Want, f.RecTime.Sub(f.AcqTime), f.AcqTime)
+		}
+	}
+	// the default is the delta of the source.
+	if f, err := parseName(name, "", 0, parseOptions{}); err != nil || f.RecTime.Sub(f.AcqTime) != 38*time.Minute {
+		t.Errorf("default: want delta of the source, got %v (%v)", f, err)
+	}
+	if _, err := parseDelta("prefix"); err == nil {
+		t.Errorf("prefix: expected error")
+	}
+}
+
+func TestParseFilenameFields(t *testing.T) {
+	const p = "0038_XYZ_1_10_20190227_101010_00.dat"
+	opts := scanOptions{
+		Parse:    parseOptions{Fields: FieldRange{First: 1, Last: 10}},
+		Rejected: new(rejectList),
+	}
+	if _, err := parseName(p, "", 0, opts.Parse); !errors.Is(err, ErrFields) {
+		t.Errorf("want fields error, got %v", err)
+	}
+	// the file is skipped without aborting the walk but rejected by -strict.
+	f, err := parseFilename(p, 0, opts)
+	if f != nil || err != nil {
+		t.Errorf("want file skipped, got %v (%v)", f, err)
+	}
+	if err := opts.Rejected.check(ioutil.Discard); err == nil {
+		t.Errorf("want file rejected")
+	}
+}
+
+func TestParseNameSource(t *testing.T) {
+	data := []struct {
+		Name   string
+		Source string
+		Err    error
+	}{
+		{Name: "0038_XYZ_1_10_20190227_101010_00.dat", Source: "38"},
+		// an hexadecimal source is parsed: the file is discarded for its origin.
+		{Name: "004a_XYZ_1_10_20190227_101010_00.dat", Source: "4a", Err: ErrOrigin},
+		{Name: "00zz_XYZ_1_10_20190227_101010_00.dat", Source: "zz", Err: ErrSource},
+		{Name: "0x38_XYZ_1_10_20190227_101010_00.dat", Source: "x38", Err: ErrSource},
+		// the source takes precedence over the other errors.
+		{Name: "00zz_XYZ_1_ab_20190227_101010_00.dat", Source: "zz", Err: ErrSource},
+	}
+	for _, d := range data {
+		f, err := parseName(d.Name, "", 0, parseOptions{})
+		if !errors.Is(err, d.Err) || (d.Err == nil && err != nil) {
+			t.Errorf("%s: want error %v, got %v", d.Name, d.Err, err)
+			continue
+		}
+		if f == nil || f.Source != d.Source {
+			t.Errorf("%s: want source %s, got %+v", d.Name, d.Source, f)
+		}
+	}
+
+	defer func(f *os.File) { os.Stderr = f }(os.Stderr)
+	os.Stderr, _ = os.Open(os.DevNull)
+
+	// the file is skipped without aborting the walk but rejected by -strict.
+	opts := scanOptions{Rejected: new(rejectList)}
+	if f, err := parseFilename(data[2].Name, 0, opts); f != nil || err != nil {
+		t.Errorf("want file skipped, got %v (%v)", f, err)
+	}
+	if err := opts.Rejected.check(ioutil.Discard); err == nil {
+		t.Errorf("want file rejected")
+	}
+
+	dir := t.TempDir()
+	testArchive(t, dir, data[0].Name, data[2].Name, "0038_XYZ_1_11_20190227_101011_00.dat")
+	if fs := collectPaths(walkFiles([]string{dir}, scanOptions{Parallel: 1})); len(fs) != 2 {
+		t.Errorf("want 2 files found, got %d", len(fs))
+	}
+}
+
+func TestInRanges(t *testing.T) {
+	const n = 200
+	orders := map[string][]uint64{
+		"sorted":  make([]uint64, 0, n),
+		"reverse": make([]uint64, 0, n),
+		"random":  make([]uint64, 0, n),
+	}
+	for i := uint64(0); i < n; i++ {
+		orders["sorted"] = append(orders["sorted"], i)
+		orders["reverse"] = append(orders["reverse"], n-1-i)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(n) {
+		orders["random"] = append(orders["random"], uint64(i))
+	}
+	for name, vs := range orders {
+		var (
+			seen []*Range
+			ok   bool
+		)
+		// every third value is missing.
+		for _, v := range vs {
+			if v%3 == 2 {
+				continue
+			}
+			if seen, ok = inRanges(seen, v); ok {
+				t.Errorf("%s: %d seen before being added", name, v)
+			}
+		}
+		// inRanges extends the ranges in place: only the values already
+		// added are given again.
+		for _, v := range vs {
+			if v%3 == 2 {
+				continue
+			}
+			if _, ok = inRanges(seen, v); !ok {
+				t.Errorf("%s: %d not reported as duplicate", name, v)
+			}
+		}
+		if want := (n + 2) / 3; len(seen) != want {
+			t.Errorf("%s: want %d ranges, got %d", name, want, len(seen))
+		}
+		for i, s := range seen {
+			if s.First != uint64(i*3) || s.Last != s.First+1 {
+				t.Errorf("%s: range %d: unexpected %s", name, i, s)
+			}
+		}
+	}
+}
+
+func BenchmarkInRanges(b *testing.B) {
+	const n = 10000
+	sorted := make([]uint64, n)
+	for i := range sorted {
+		// one value out of hundred is missing.
+		sorted[i] = uint64(i + i/99)
+	}
+	shuffled := make([]uint64, n)
+	copy(shuffled, sorted)
+	rand.New(rand.NewSource(1)).Shuffle(n, func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	for name, vs := range map[string][]uint64{"sorted": sorted, "shuffled": shuffled} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var seen []*Range
+				for _, v := range vs {
+					if s, ok := inRanges(seen, v); !ok {
+						seen = s
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCozeFirstLastTies(t *testing.T) {
+	// the files 1 to 4 are acquired at the same time, and so are the files
+	// 5 to 8: whatever their order, the lowest and highest counters win.
+	var fs []*File
+	for i := uint64(1); i <= 8; i++ {
+		f := testFile("XYZ", i, false)
+		switch {
+		case i <= 4:
+			f.AcqTime = testEpoch
+		default:
+			f.AcqTime = testEpoch.Add(time.Minute)
+		}
+		fs = append(fs, f)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		r.Shuffle(len(fs), func(i, j int) { fs[i], fs[j] = fs[j], fs[i] })
+
+		var c Coze
+		for _, f := range fs {
+			c.Update(f)
+		}
+		if c.First != 1 || c.Last != 8 {
+			t.Fatalf("want first 1 and last 8, got %d and %d", c.First, c.Last)
+		}
+		if !c.Starts.Equal(testEpoch) || !c.Ends.Equal(testEpoch.Add(time.Minute)) {
+			t.Fatalf("unexpected period %s - %s", c.Starts, c.Ends)
+		}
+	}
+}
+
+func TestCozeLongestRun(t *testing.T) {
+	data := []struct {
+		Seqs        []int
+		First, Last uint64
+	}{
+		{Seqs: []int{1, 2, 3, 5, 6, 7, 8, 10}, First: 5, Last: 8},
+		// the first of the widest runs wins.
+		{Seqs: []int{10, 11, 12, 1, 2, 3, 20}, First: 1, Last: 3},
+		{Seqs: []int{7, 1, 3, 5}, First: 1, Last: 1},
+		{Seqs: []int{4, 3, 2, 1, 9, 8}, First: 1, Last: 4},
+		// an invalid file breaks a run.
+		{Seqs: []int{1, 2, -3, 4, 5, 6}, First: 4, Last: 6},
+	}
+	for _, d := range data {
+		c := testCoze("XYZ", d.Seqs...)
+		r := c.LongestRun()
+		if r == nil || r.First != d.First || r.Last != d.Last {
+			t.Errorf("%v: want longest run [%d, %d], got %v", d.Seqs, d.First, d.Last, r)
+		}
+	}
+	if r := testCoze("XYZ").LongestRun(); r != nil {
+		t.Errorf("no file: want no longest run, got %s", r)
+	}
+}
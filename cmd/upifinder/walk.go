@@ -1,10 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/midbel/cli"
@@ -12,7 +17,7 @@ import (
 )
 
 var walkCommand = &cli.Command{
-	Usage: "walk [-d] [-s] [-e] [-u] [-c] <archive,...>",
+	Usage: "walk [-d] [-s] [-e] [-year] [-doy] [-u] [-c] [-header] [-decimal] [-thousands] [-k] [-count-only] [-z] [-stale] [-top] [-by] [-expect] [-split-dir] [-minsize] [-maxsize] [-stored] [-longest] [-group] [-header-time] [-tz] [-strict] [-logfile] [-from] [-digest] [-conflicts] [-prefer] [-sample] [-spill] [-seq64] [-no-recurse] [-drops] [-config] [-ignore-ext] <archive,...>",
 	Short: "provide the number of files available in the archive",
 	Alias: []string{"scan", "report"},
 	Run:   runWalk,
@@ -29,8 +34,14 @@ of the value given to the command line):
   * [s] + [d] : walk from START to START + DAYS date
   * [e] + [d] : walk from END - DAYS to END date
   * [d]       : walk from TODAY - DAYS to TODAY
+  * [doy]     : walk the days of year DOY of YEAR (or of the current year)
   * default   : walk recursively on the given path(s)
 
+A path without YYYY sub directories (eg an export directory) is always walked
+recursively whatever the period, with a warning. When its sub directories have
+YYYY sub directories (eg the parent directory of the sources), the period is
+selected in these sub directories instead.
+
 Unique files:
 
 the uniq field only reports the number of unique files (correct) excluding bad files
@@ -39,11 +50,99 @@ from the count and the doubles.
 Options:
 
   -u UPI     only count files for the given UPI
+  -buffer N  number of files found in advance of their processing (default 1024)
+  -config FILE  read the options not given on the command line from FILE (see
+             configuration file in README)
+  -no-recurse  only find the files directly in the given paths (or in the paths
+             selected for the period) and skip their sub directories
+  -per-root N  walk at most N paths at the same time under each given path
   -s START   only count files created after START
   -e END     only count files created before END
   -d DAYS    only count files created during a period of DAYS
+  -year YEAR  year of the days given to -doy (default current year)
+  -doy DOY   only count files created during the day of year DOY (1-366). Can be
+             repeated or given as a comma separated list
   -c         print the results as csv
+  -header    print the names of the columns as first row of the csv (default
+             true, disabled with -header=false)
+  -decimal C  separator of the decimals of the ratios written as csv (default .)
+  -thousands C  separator of the thousands of the sizes and ratios written as
+             csv (default none)
+  -k         keep invalid files in the count of missing files: a sequence
+             counter only found in an invalid file is not missing
+  -count-only  only count the files and their size without tracking their
+             sequence counters (faster): uniq, missing and completeness are
+             n/a and the size includes the duplicated files
   -z         discard UPI that have no missing files
+  -stale DURATION  only print the UPI whose most recent file has been acquired
+             more than DURATION ago (eg 6h)
+  -top N     only print the N UPI ranked first (see -by)
+  -by KEY    rank UPI by missing, invalid, count or size (default missing with
+             -top). Without -top, all the UPI are printed in the order of KEY
+  -expect FILE  report the UPI listed in FILE even when no files are found
+  -split-dir DIR  write the results of each UPI as csv in its own file into DIR
+             (the UPI with the same name once sanitized get a hash suffix)
+  -minsize N  only count files of at least N bytes
+  -maxsize N  only count files of at most N bytes
+  -sample N  only count one file out of N (selected from its path) and multiply
+             the counts and sizes by N to give an estimate (see below)
+  -spill DIR  write the files found into temporary files in DIR and count the
+             files of each temporary file on its own to bound the memory used
+             by the ranges of sequence counters of many UPI with gaps (slower).
+             One row by UPI is still kept in memory
+  -stored    print the size of the files as stored in the archive (compressed)
+             and the ratio between stored and uncompressed sizes
+  -longest   print the first and last sequence counters of the longest run of
+             consecutive sequence counters of each UPI and its number of files
+  -group BY  count files by UPI and by day, week (ISO 8601) or month of their
+             acquisition time
+  -header-time  read the acquisition time of the files found on the filesystem
+             from their header (GPS time) instead of their filename (slower)
+  -tz ZONE   print the times in the timezone ZONE (IANA name, eg Europe/Brussels)
+             instead of UTC
+  -strict    fail with the list of the files that can not be parsed or that
+             are discarded (name, pattern, type, origin or source)
+  -logfile FILE  also write the results to FILE (reopened on SIGHUP)
+  -from FILE  read the files from FILE (the output of files -j) instead of
+             the archive
+  -conflicts  report on stderr the files (same UPI and sequence counter) found
+             in different kinds of location: loose (filesystem), lst, tar or
+             zip
+  -prefer KIND  like -conflicts but only keep the files found in a location of
+             KIND (loose, lst, tar or zip) when they are in conflict
+             (with -conflicts and -prefer, all the files found are kept in memory
+             until the end of the walk)
+  -digest FILE  count the files without checksum in FILE (a report of digest)
+  -origin T=LIST  accept the origins given by LIST for the files of type T. LIST
+                  is images, sciences or a list of hexadecimal origins
+  -pattern RE  parse the filenames with the given regular expression instead
+                of splitting them on underscores (see below)
+  -upi-fields F:L  build the UPI with the fields F to L (excluded) of the
+                filename. Negative values count from the end (default 1:-5)
+  -ignore-ext EXT  skip the files with the extension EXT (eg .md5) in addition
+             to the xml files. Can be repeated or given as a comma separated list
+  -drops FILE  write to FILE (csv) the files dropped because their filename can
+             not be parsed or is discarded, with the code of the reason (see
+             README)
+  -seq64     accept sequence counters encoded on 64 bits (default 32 bits)
+  -delta WHAT   compute the reception time from the source (first field
+                of the filename) or the suffix (last field of the filename)
+                given as a number of minutes (default source)
+
+Sampling:
+
+with -sample, the results are estimates computed from a subset of the files. As
+the sequence counters of the files not sampled are unknown, the sequence
+counters are not tracked (like with -count-only): uniq, missing and
+completeness are n/a.
+
+Filename pattern:
+
+the regular expression given to -pattern should define the named groups source
+(hexadecimal), upi, sequence and time (YYYYmmddHHMMSS). A date group (YYYYmmdd)
+can be given and then time only contains HHMMSS. The type and delta groups are
+optional and are used to select the accepted origins and to compute the
+reception time.
 
 Examples:
 
@@ -62,86 +161,625 @@ $ upifinder -u XYZ -s 2018-06-04 -e 2018-06-11 /data/images/playback/38
 Developed with %s by GC`,
 }
 
+// walkConfig holds the options of walk given on the command line or in the
+// configuration file (see -config).
+type walkConfig struct {
+	Start, End  When
+	UPI         string
+	Buffer      int
+	Config      string
+	NoRecurse   bool
+	PerRoot     int
+	Period      int
+	Year        int
+	Days        DayList
+	CSV         bool
+	Header      bool
+	KeepInvalid bool
+	CountOnly   bool
+	Zero        bool
+	Top         int
+	By          string
+	Delta       string
+	Parse       parseOptions
+	Ignored     ExtList
+	Drops       string
+	Pattern     string
+	Zone        string
+	Expect      string
+	Split       string
+	MinSize     int64
+	MaxSize     int64
+	Sample      int
+	Spill       string
+	HeaderTime  bool
+	Stored      bool
+	Longest     bool
+	Decimal     string
+	Thousands   string
+	Group       string
+	Stale       time.Duration
+	Logfile     string
+	Strict      bool
+	From        string
+	Digest      string
+	Conflicts   bool
+	Prefer      string
+}
+
+// newWalkConfig defines the flags of walk in set.
+func newWalkConfig(set *flag.FlagSet) *walkConfig {
+	c := walkConfig{
+		Parse: newParseOptions(),
+	}
+	set.Var(&c.Start, "s", "start")
+	set.Var(&c.End, "e", "end")
+	set.StringVar(&c.UPI, "u", "", "upi")
+	set.IntVar(&c.Buffer, "buffer", DefaultBuffer, "buffer")
+	set.StringVar(&c.Config, "config", "", "config file")
+	set.BoolVar(&c.NoRecurse, "no-recurse", false, "no recursion")
+	set.IntVar(&c.PerRoot, "per-root", 0, "paths per root")
+	set.IntVar(&c.Period, "d", 0, "period")
+	set.IntVar(&c.Year, "year", 0, "year")
+	set.Var(&c.Days, "doy", "days of year")
+	set.BoolVar(&c.CSV, "c", false, "csv")
+	set.BoolVar(&c.Header, "header", true, "csv header")
+	set.BoolVar(&c.KeepInvalid, "k", false, "keep invalid files")
+	set.BoolVar(&c.CountOnly, "count-only", false, "count only")
+	set.BoolVar(&c.Zero, "z", false, "discard row with zero missing")
+	set.IntVar(&c.Top, "top", 0, "top")
+	set.StringVar(&c.By, "by", "", "rank by")
+	set.StringVar(&c.Delta, "delta", "source", "delta")
+	set.Var(&c.Parse.Fields, "upi-fields", "upi fields")
+	set.Var(&c.Ignored, "ignore-ext", "ignored extensions")
+	set.StringVar(&c.Drops, "drops", "", "dropped files")
+	set.BoolVar(&c.Parse.Long, "seq64", false, "64 bits sequence")
+	set.StringVar(&c.Pattern, "pattern", "", "filename pattern")
+	set.StringVar(&c.Zone, "tz", "", "timezone")
+	set.Var(c.Parse.Origins, "origin", "origins by type")
+	set.StringVar(&c.Expect, "expect", "", "expected upi")
+	set.StringVar(&c.Split, "split-dir", "", "split results by upi")
+	set.Int64Var(&c.MinSize, "minsize", 0, "minimum size")
+	set.Int64Var(&c.MaxSize, "maxsize", 0, "maximum size")
+	set.IntVar(&c.Sample, "sample", 0, "sample rate")
+	set.StringVar(&c.Spill, "spill", "", "spill directory")
+	set.BoolVar(&c.HeaderTime, "header-time", false, "header time")
+	set.BoolVar(&c.Stored, "stored", false, "stored size")
+	set.BoolVar(&c.Longest, "longest", false, "longest run")
+	set.StringVar(&c.Decimal, "decimal", ".", "decimal separator")
+	set.StringVar(&c.Thousands, "thousands", "", "thousands separator")
+	set.StringVar(&c.Group, "group", "", "group by period")
+	set.DurationVar(&c.Stale, "stale", 0, "stale")
+	set.StringVar(&c.Logfile, "logfile", "", "log file")
+	set.BoolVar(&c.Strict, "strict", false, "strict")
+	set.StringVar(&c.From, "from", "", "files list")
+	set.StringVar(&c.Digest, "digest", "", "digest manifest")
+	set.BoolVar(&c.Conflicts, "conflicts", false, "report conflicts")
+	set.StringVar(&c.Prefer, "prefer", "", "prefer provenance")
+	return &c
+}
+
 func runWalk(cmd *cli.Command, args []string) error {
 	cmd.Desc = fmt.Sprintf(cmd.Desc, "\u2764")
 
-	var start, end When
-	cmd.Flag.Var(&start, "s", "start")
-	cmd.Flag.Var(&end, "e", "end")
-	upi := cmd.Flag.String("u", "", "upi")
-	period := cmd.Flag.Int("d", 0, "period")
-	csv := cmd.Flag.Bool("c", false, "csv")
-	zero := cmd.Flag.Bool("z", false, "discard row with zero missing")
+	c := newWalkConfig(&cmd.Flag)
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	if err := loadConfig(&cmd.Flag, c.Config, "walk"); err != nil {
+		return err
+	}
+	var err error
+	if c.Parse.Delta, err = parseDelta(c.Delta); err != nil {
+		return err
+	}
+	if c.Parse.Pattern, err = compilePattern(c.Pattern); err != nil {
+		return err
+	}
+	zone, err := loadZone(c.Zone)
+	if err != nil {
+		return err
+	}
+	group, err := groupBy(c.Group)
+	if err != nil {
+		return err
+	}
+	numbers, err := newNumberFormat(c.Decimal, c.Thousands)
+	if err != nil {
+		return err
+	}
+	less, err := rankBy(c.By)
+	if err != nil {
+		return err
+	}
+	if err := checkProvenance(c.Prefer); err != nil {
+		return err
+	}
+	if c.Sample > 1 {
+		if c.Zero {
+			return fmt.Errorf("sample and z can not be set together")
+		}
+		c.CountOnly = true
+	}
+	if c.CountOnly && c.Zero {
+		return fmt.Errorf("count-only and z can not be set together")
+	}
 
-	if cmd.Flag.NArg() == 0 {
+	if cmd.Flag.NArg() == 0 && c.From == "" {
 		cmd.Help()
 	}
 
-	paths, err := listPaths(cmd.Flag.Args(), *period, start.Time, end.Time)
-	if err != nil {
+	var upis []string
+	if c.Expect != "" {
+		if upis, err = readExpected(c.Expect); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if c.Logfile != "" {
+		f, err := openLog(c.Logfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = io.MultiWriter(w, f)
+	}
+	var drops *dropWriter
+	if c.Drops != "" {
+		f, err := os.Create(c.Drops)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		drops = newDropWriter(f)
+	}
+	var rejected *rejectList
+	if c.Strict {
+		rejected = new(rejectList)
+	}
+
+	var queue <-chan *File
+	if c.From != "" {
+		fs, err := loadFiles(c.From, c.UPI)
+		if err != nil {
+			return err
+		}
+		queue = feedFiles(fs)
+	} else {
+		paths, err := selectPaths(cmd.Flag.Args(), c.Period, c.Start.Time, c.End.Time, c.Year, c.Days)
+		if err != nil {
+			return err
+		}
+		queue = walkFiles(paths, scanOptions{
+			UPI:       c.UPI,
+			Parallel:  8,
+			Buffer:    c.Buffer,
+			NoRecurse: c.NoRecurse,
+			PerRoot:   c.PerRoot,
+			Roots:     cmd.Flag.Args(),
+			KeepTwins: c.Conflicts || c.Prefer != "",
+			Ignored:   c.Ignored,
+			Parse:     c.Parse,
+			Rejected:  rejected,
+			Drops:     drops,
+		})
+	}
+	if c.Conflicts || c.Prefer != "" {
+		queue = preferFiles(queue, c.Prefer, os.Stderr)
+	}
+	if c.HeaderTime {
+		queue = joinHeaderTimes(queue)
+	}
+	if c.MinSize > 0 || c.MaxSize > 0 {
+		queue = filterFiles(queue, bySize(c.MinSize, c.MaxSize))
+	}
+	if c.Sample > 1 {
+		queue = filterFiles(queue, bySample(c.Sample))
+	}
+	var unchecked map[string]uint64
+	if c.Digest != "" {
+		ds, err := readDigests(c.Digest)
+		if err != nil {
+			return err
+		}
+		queue, unchecked = countUnchecked(joinDigests(queue, ds))
+	}
+	count := countOptions{
+		CountOnly:   c.CountOnly,
+		KeepInvalid: c.KeepInvalid,
+		Group:       group,
+	}
+	var rs map[string]*Coze
+	if c.Spill != "" {
+		if rs, err = spillFiles(queue, c.Spill, count); err != nil {
+			return err
+		}
+	} else {
+		rs = countFiles(queue, runtime.NumCPU(), count)
+	}
+	if err := rejected.check(os.Stderr); err != nil {
 		return err
 	}
-	if rs := countFiles(walkFiles(paths, *upi, 8)); len(rs) > 0 {
-		reportWalkResults(rs, *csv, *zero)
+	if c.Sample > 1 {
+		scaleCozes(rs, c.Sample)
+		fmt.Fprintf(os.Stderr, "estimated results: one file out of %d counted\n", c.Sample)
+	}
+	expectCozes(rs, upis)
+	if len(rs) > 0 {
+		now := time.Now()
+		cs := sortCozes(rs, c.Zero)
+		if c.Stale > 0 {
+			cs = staleCozes(cs, now, c.Stale)
+		}
+		if c.Top > 0 || c.By != "" {
+			cs = rankCozes(cs, c.Top, less)
+		}
+		opts := walkOptions{
+			CSV:     c.CSV,
+			Header:  c.Header,
+			Expect:  len(upis) > 0,
+			Stored:  c.Stored,
+			Run:     c.Longest,
+			Group:   group != nil,
+			Count:   c.CountOnly,
+			Now:     now,
+			Zone:    zone,
+			Numbers: numbers,
+
+			Unchecked: unchecked,
+		}
+		if c.Split != "" {
+			return splitWalkResults(c.Split, cs, opts)
+		}
+		reportWalkResults(w, cs, opts)
 	}
 	return nil
 }
 
-func reportWalkResults(rs map[string]*Coze, csv, zero bool) {
-	vs := make([]string, 0, len(rs))
-	for n := range rs {
-		vs = append(vs, n)
+// walkOptions controls the columns printed by reportWalkResults.
+type walkOptions struct {
+	CSV    bool
+	Header bool
+	Expect bool
+	Stored bool
+	// Run is set to print the longest run of consecutive sequence counters.
+	Run   bool
+	Group bool
+	// Count is set when the sequence counters are not tracked (see -count-only):
+	// uniq, missing and completeness are then not available.
+	Count bool
+	// Now is the time used to compute the age of the most recent file.
+	Now time.Time
+	// Zone is the timezone of the times printed (see -tz).
+	Zone *time.Location
+	// Numbers are the separators of the numbers written as csv.
+	Numbers numberFormat
+
+	// Unchecked, when not nil, gives by UPI the number of files that have no
+	// checksum.
+	Unchecked map[string]uint64
+}
+
+// splitWalkResults writes the results of each UPI as csv in its own file into
+// dir. Existing files are overwritten. The names of the files are given by
+// fileNames.
+func splitWalkResults(dir string, cs []*Coze, opts walkOptions) error {
+	opts.CSV = true
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
 	}
-	sort.Strings(vs)
-	line := Line(csv)
-	for _, n := range vs {
-		c := rs[n]
-		if zero && c.Missing() == 0 {
-			continue
+	var (
+		upis   []string
+		groups = make(map[string][]*Coze)
+	)
+	for _, c := range cs {
+		if _, ok := groups[c.UPI]; !ok {
+			upis = append(upis, c.UPI)
 		}
+		// more than one Coze by UPI when they are grouped by period.
+		groups[c.UPI] = append(groups[c.UPI], c)
+	}
+	names := fileNames(upis)
+	for _, u := range upis {
+		w, err := os.Create(filepath.Join(dir, names[u]+".csv"))
+		if err != nil {
+			return err
+		}
+		reportWalkResults(w, groups[u], opts)
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkColumns gives the names of the columns printed by reportWalkResults.
+func walkColumns(opts walkOptions) []string {
+	cols := []string{"upi"}
+	if opts.Group {
+		cols = append(cols, "period")
+	}
+	cols = append(cols, "total", "uniq", "size")
+	if opts.Stored {
+		cols = append(cols, "stored", "compression")
+	}
+	cols = append(cols, "invalid", "ratio", "acq_start", "acq_end", "seq_start", "seq_end", "missing", "completeness", "age")
+	if opts.Run {
+		cols = append(cols, "run_start", "run_end", "run")
+	}
+	if opts.Unchecked != nil {
+		cols = append(cols, "unchecked")
+	}
+	if opts.Expect {
+		cols = append(cols, "status")
+	}
+	return cols
+}
 
+func reportWalkResults(w io.Writer, cs []*Coze, opts walkOptions) {
+	csv := opts.CSV
+	if csv && opts.Header {
+		writeHeader(w, walkColumns(opts))
+	}
+	line := Line(csv)
+	for _, c := range cs {
 		first, last := c.Range()
+		if opts.Count {
+			first, last = c.First, c.Last
+		}
 
 		line.AppendString(Transform(c.UPI), 24, linewriter.AlignLeft)
+		if opts.Group {
+			line.AppendString(c.Period, 10, linewriter.AlignLeft)
+		}
 		line.AppendUint(c.Count, 10, linewriter.AlignRight)
-		line.AppendUint(c.Uniq, 10, linewriter.AlignRight)
+		if opts.Count {
+			line.AppendString("n/a", 10, linewriter.AlignRight)
+		} else {
+			line.AppendUint(c.Uniq, 10, linewriter.AlignRight)
+		}
 		if csv {
-			line.AppendUint(c.Size, 10, linewriter.AlignRight)
+			opts.Numbers.appendUint(line, c.Size)
 		} else {
 			line.AppendSize(int64(c.Size), 10, linewriter.AlignRight)
 		}
+		if opts.Stored {
+			if csv {
+				opts.Numbers.appendUint(line, c.Stored)
+				opts.Numbers.appendFloat(line, c.Compression())
+			} else {
+				line.AppendSize(int64(c.Stored), 10, linewriter.AlignRight)
+				line.AppendPercent(c.Compression(), 10, 2, linewriter.AlignRight)
+			}
+		}
 		line.AppendUint(c.Invalid, 10, linewriter.AlignRight)
 		if ratio := c.Corrupted(); csv {
-			line.AppendFloat(ratio, 10, 2, linewriter.AlignRight)
+			opts.Numbers.appendFloat(line, ratio)
 		} else {
 			line.AppendPercent(ratio, 10, 2, linewriter.AlignRight)
 		}
-		line.AppendTime(c.Starts, time.RFC3339, linewriter.AlignRight)
-		line.AppendTime(c.Ends, time.RFC3339, linewriter.AlignRight)
-		line.AppendUint(uint64(first), 10, linewriter.AlignRight)
-		line.AppendUint(uint64(last), 10, linewriter.AlignRight)
-		line.AppendUint(c.Missing(), 10, linewriter.AlignRight)
+		line.AppendTime(Local(c.Starts, opts.Zone), time.RFC3339, linewriter.AlignRight)
+		line.AppendTime(Local(c.Ends, opts.Zone), time.RFC3339, linewriter.AlignRight)
+		line.AppendUint(first, 10, linewriter.AlignRight)
+		line.AppendUint(last, 10, linewriter.AlignRight)
+		switch ratio := c.Completeness(); {
+		case opts.Count:
+			line.AppendString("n/a", 10, linewriter.AlignRight)
+			line.AppendString("n/a", 10, linewriter.AlignRight)
+		case csv:
+			line.AppendUint(c.Missing(), 10, linewriter.AlignRight)
+			opts.Numbers.appendFloat(line, ratio)
+		default:
+			line.AppendUint(c.Missing(), 10, linewriter.AlignRight)
+			line.AppendPercent(ratio, 10, 2, linewriter.AlignRight)
+		}
+		if age := c.Age(opts.Now); csv {
+			line.AppendUint(uint64(age.Seconds()), 10, linewriter.AlignRight)
+		} else {
+			line.AppendDuration(age, 10, linewriter.AlignRight)
+		}
+		if opts.Run {
+			appendRun(line, c.LongestRun(), opts.Count)
+		}
+		if opts.Unchecked != nil {
+			line.AppendUint(opts.Unchecked[c.UPI], 10, linewriter.AlignRight)
+		}
+		if opts.Expect {
+			appendStatus(line, c.absent)
+		}
+
+		io.Copy(w, line)
+	}
+}
+
+// appendRun appends the first and last sequence counters of r and its number of
+// files. They are n/a when the sequence counters are not tracked and zero when
+// there is no file.
+func appendRun(line *linewriter.Writer, r *Range, count bool) {
+	switch {
+	case count:
+		line.AppendString("n/a", 10, linewriter.AlignRight)
+		line.AppendString("n/a", 10, linewriter.AlignRight)
+		line.AppendString("n/a", 10, linewriter.AlignRight)
+	case r == nil:
+		line.AppendUint(0, 10, linewriter.AlignRight)
+		line.AppendUint(0, 10, linewriter.AlignRight)
+		line.AppendUint(0, 10, linewriter.AlignRight)
+	default:
+		line.AppendUint(r.First, 10, linewriter.AlignRight)
+		line.AppendUint(r.Last, 10, linewriter.AlignRight)
+		line.AppendUint(r.Total()+1, 10, linewriter.AlignRight)
+	}
+}
+
+func sortCozes(rs map[string]*Coze, zero bool) []*Coze {
+	vs := make([]string, 0, len(rs))
+	for n := range rs {
+		vs = append(vs, n)
+	}
+	sort.Strings(vs)
+
+	cs := make([]*Coze, 0, len(vs))
+	for _, n := range vs {
+		c := rs[n]
+		if zero && c.Missing() == 0 && !c.absent {
+			continue
+		}
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+// scaleCozes multiplies the counts and the sizes of the Coze of rs by n to
+// estimate them from a sample of one file out of n (see -sample).
+func scaleCozes(rs map[string]*Coze, n int) {
+	for _, c := range rs {
+		c.Count *= uint64(n)
+		c.Invalid *= uint64(n)
+		c.Size *= uint64(n)
+		c.Stored *= uint64(n)
+	}
+}
+
+// staleCozes keeps the Coze of cs whose most recent file is older than d. The
+// UPI without file (see -expect) are always kept.
+func staleCozes(cs []*Coze, now time.Time, d time.Duration) []*Coze {
+	var rs []*Coze
+	for _, c := range cs {
+		if c.absent || c.Age(now) > d {
+			rs = append(rs, c)
+		}
+	}
+	return rs
+}
+
+// rankCozes keeps the n first Coze of cs once ordered by less (all of them when
+// n is 0). The sort is stable so UPI with the same rank keep their
+// alphabetical order.
+func rankCozes(cs []*Coze, n int, less func(a, b *Coze) bool) []*Coze {
+	sort.SliceStable(cs, func(i, j int) bool {
+		return less(cs[i], cs[j])
+	})
+	if n > 0 && n < len(cs) {
+		cs = cs[:n]
+	}
+	return cs
+}
+
+func rankBy(by string) (func(a, b *Coze) bool, error) {
+	var less func(a, b *Coze) bool
+	switch strings.ToLower(by) {
+	case "missing", "":
+		less = func(a, b *Coze) bool { return a.Missing() > b.Missing() }
+	case "invalid":
+		less = func(a, b *Coze) bool { return a.Invalid > b.Invalid }
+	case "count":
+		less = func(a, b *Coze) bool { return a.Count > b.Count }
+	case "size":
+		less = func(a, b *Coze) bool { return a.Size > b.Size }
+	default:
+		return nil, fmt.Errorf("unsupported %s", by)
+	}
+	return less, nil
+}
+
+// countOptions controls how the files of an UPI are counted by a Coze.
+type countOptions struct {
+	// CountOnly makes a Coze only count the files and their size without
+	// tracking their sequence counters: Uniq and Missing are not computed and
+	// the size includes the duplicated files (see -count-only).
+	CountOnly bool
+	// KeepInvalid makes the sequence counter of an invalid file known by a
+	// Coze: the file is present (but invalid) and is not counted as missing. It
+	// is still counted in Invalid and never in Uniq. A valid file with the same
+	// sequence counter is counted in Uniq whatever the order the files are
+	// found (see -k).
+	KeepInvalid bool
+	// Group, when set, gives the period (day, week or month) of a file: the
+	// files of an UPI are counted separately by period (see -group).
+	Group func(time.Time) string
+	// Profile, when set, records the time spent to track the sequence
+	// counters (see stats).
+	Profile *scanProfile
+}
+
+// countFiles aggregates the files of queue by UPI. When n is greater than one,
+// the aggregation is shared between n goroutines. The files of an UPI are
+// always given to the same goroutine to keep the order in which they are found.
+func countFiles(queue <-chan *File, n int, opts countOptions) map[string]*Coze {
+	if n <= 1 {
+		return countShard(queue, opts)
+	}
+	var (
+		wg     sync.WaitGroup
+		qs     = make([]chan *File, n)
+		shards = make([]map[string]*Coze, n)
+	)
+	for i := range qs {
+		qs[i] = make(chan *File, 64)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shards[i] = countShard(qs[i], opts)
+		}(i)
+	}
+	for f := range queue {
+		qs[shardOf(f, n)] <- f
+	}
+	for i := range qs {
+		close(qs[i])
+	}
+	wg.Wait()
+
+	rs := shards[0]
+	for _, s := range shards[1:] {
+		for k, c := range s {
+			rs[k] = c
+		}
+	}
+	return rs
+}
 
-		io.Copy(os.Stdout, line)
+// shardOf gives the goroutine of countFiles to which f is given among n. It
+// computes the FNV-1a hash of the UPI of f (see File.String) without building
+// it to not allocate for each file.
+func shardOf(f *File, n int) int {
+	const (
+		offset = 2166136261
+		prime  = 16777619
+	)
+	h := uint32(offset)
+	for i := 0; i < len(f.Source); i++ {
+		h = (h ^ uint32(f.Source[i])) * prime
+	}
+	h = (h ^ '/') * prime
+	for i := 0; i < len(f.Info); i++ {
+		h = (h ^ uint32(f.Info[i])) * prime
 	}
+	return int(h % uint32(n))
 }
 
-func countFiles(queue <-chan *File) map[string]*Coze {
+func countShard(queue <-chan *File, opts countOptions) map[string]*Coze {
 	rs := make(map[string]*Coze)
 
 	for f := range queue {
 		k := f.String()
+		var period string
+		if opts.Group != nil {
+			period = opts.Group(f.AcqTime)
+			k += "/" + period
+		}
 		c, ok := rs[k]
 		if !ok {
 			c = &Coze{
 				UPI:    f.String(),
+				Period: period,
 				First:  f.Sequence,
 				Last:   f.Sequence,
 				Starts: f.AcqTime,
 				Ends:   f.AcqTime,
+				opts:   opts,
 			}
 			rs[k] = c
 		}
@@ -149,3 +787,29 @@ func countFiles(queue <-chan *File) map[string]*Coze {
 	}
 	return rs
 }
+
+// groupBy gives the period (day, week or month) used by walk to count the files
+// of an UPI separately. No period is given when v is empty.
+func groupBy(v string) (func(time.Time) string, error) {
+	switch strings.ToLower(v) {
+	case "":
+		return nil, nil
+	case "day":
+		return func(t time.Time) string {
+			return t.UTC().Format("2006-01-02")
+		}, nil
+	case "week":
+		// the year of the ISO week can differ from the year of t (eg the
+		// 2019-12-30 is in the week 1 of 2020).
+		return func(t time.Time) string {
+			y, w := t.UTC().ISOWeek()
+			return fmt.Sprintf("%04d-W%02d", y, w)
+		}, nil
+	case "month":
+		return func(t time.Time) string {
+			return t.UTC().Format("2006-01")
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported group %s", v)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2019, 2, 27, 10, 0, 0, 0, time.UTC)
+
+// testFile gives a file of the UPI upi of source 38 with the sequence counter
+// seq acquired seq seconds after testEpoch. The file is invalid when bad is set.
+func testFile(upi string, seq uint64, bad bool) *File {
+	p := fmt.Sprintf("0038_%s_%d.dat", upi, seq)
+	if bad {
+		p += ".bad"
+	}
+	return &File{
+		Path:     p,
+		Source:   "38",
+		Info:     upi,
+		Size:     100,
+		Sequence: seq,
+		AcqTime:  testEpoch.Add(time.Duration(seq) * time.Second),
+	}
+}
+
+// testCoze gives the Coze of the files of upi with the given sequence
+// counters. Negative sequence counters are given to invalid files.
+func testCoze(upi string, seqs ...int) *Coze {
+	return testCozeWith(countOptions{}, upi, seqs...)
+}
+
+// testCozeWith is testCoze with the files counted with opts.
+func testCozeWith(opts countOptions, upi string, seqs ...int) *Coze {
+	c := &Coze{UPI: "38/" + upi, opts: opts}
+	for _, s := range seqs {
+		if s < 0 {
+			c.Update(testFile(upi, uint64(-s), true))
+		} else {
+			c.Update(testFile(upi, uint64(s), false))
+		}
+	}
+	return c
+}
+
+func TestRankCozes(t *testing.T) {
+	rs := map[string]*Coze{
+		"38/AAA": testCoze("AAA", 1, 2, 3, 4),
+		"38/BBB": testCoze("BBB", 1, 5, 10),
+		"38/CCC": testCoze("CCC", 1, 3, -4, 5),
+		"38/DDD": testCoze("DDD", 1, 3, 5, 7, 9, 11),
+	}
+	data := []struct {
+		By   string
+		Top  int
+		Want []string
+	}{
+		{By: "missing", Top: 2, Want: []string{"38/BBB", "38/DDD"}},
+		{By: "missing", Top: 10, Want: []string{"38/BBB", "38/DDD", "38/CCC", "38/AAA"}},
+		{By: "invalid", Top: 1, Want: []string{"38/CCC"}},
+		{By: "count", Top: 3, Want: []string{"38/DDD", "38/AAA", "38/CCC"}},
+		{By: "size", Top: 1, Want: []string{"38/DDD"}},
+		{Top: 1, Want: []string{"38/BBB"}},
+		// without -top, all the UPI are ordered.
+		{By: "count", Want: []string{"38/DDD", "38/AAA", "38/CCC", "38/BBB"}},
+	}
+	for _, d := range data {
+		less, err := rankBy(d.By)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", d.By, err)
+		}
+		cs := rankCozes(sortCozes(rs, false), d.Top, less)
+		if len(cs) != len(d.Want) {
+			t.Errorf("%s/%d: want %d rows, got %d", d.By, d.Top, len(d.Want), len(cs))
+			continue
+		}
+		for i, c := range cs {
+			if c.UPI != d.Want[i] {
+				t.Errorf("%s/%d: row %d: want %s, got %s", d.By, d.Top, i, d.Want[i], c.UPI)
+			}
+		}
+	}
+	if _, err := rankBy("unknown"); err == nil {
+		t.Errorf("unknown key: expected error")
+	}
+}
+
+func TestFileNamesDeterministic(t *testing.T) {
+	defer func(f *os.File) { os.Stderr = f }(os.Stderr)
+
+	upis := []string{"38/X.Y", "38/C.D", "38/A.B", "38/X_Y", "38/A_B", "38/C_D"}
+	run := func() string {
+		p := filepath.Join(t.TempDir(), "stderr")
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		os.Stderr = f
+		fileNames(upis)
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+	want := run()
+	if n := strings.Count(want, "\n"); n != 3 {
+		t.Fatalf("want 3 collisions reported, got %d", n)
+	}
+	if !strings.HasPrefix(want, "38_A_B:") {
+		t.Errorf("want collisions ordered by filename, got\n%s", want)
+	}
+	for i := 0; i < 10; i++ {
+		if got := run(); got != want {
+			t.Fatalf("run %d: collisions differ: want\n%s\ngot\n%s", i+2, want, got)
+		}
+	}
+}
+
+func TestSplitWalkResults(t *testing.T) {
+	dir := t.TempDir()
+	cs := []*Coze{
+		testCoze("A.B", 1, 2, 3),
+		testCoze("A_B", 1, 3),
+		testCoze("XYZ", 1, 2),
+	}
+	cs[0].UPI, cs[1].UPI = "38/A.B", "38/A_B"
+	// files of a previous run are overwritten.
+	ioutil.WriteFile(filepath.Join(dir, "38_XYZ.csv"), []byte("old content\n"), 0644)
+
+	if err := splitWalkResults(filepath.Join(dir, "split"), cs, walkOptions{Header: true}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := splitWalkResults(dir, cs, walkOptions{Header: true}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	names := fileNames([]string{"38/A.B", "38/A_B", "38/XYZ"})
+	if names["38/A.B"] == names["38/A_B"] {
+		t.Fatalf("same filename for 38/A.B and 38/A_B: %s", names["38/A.B"])
+	}
+	if names["38/XYZ"] != "38_XYZ" {
+		t.Errorf("38/XYZ: want 38_XYZ, got %s", names["38/XYZ"])
+	}
+	for _, c := range cs {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, names[c.UPI]+".csv"))
+		if err != nil {
+			t.Errorf("%s: %s", c.UPI, err)
+			continue
+		}
+		rows := strings.Split(strings.TrimSpace(string(buf)), "\n")
+		if len(rows) != 2 {
+			t.Errorf("%s: want header and one row, got %d rows", c.UPI, len(rows))
+			continue
+		}
+		if !strings.HasPrefix(rows[0], "upi") || !strings.Contains(rows[1], Transform(c.UPI)) {
+			t.Errorf("%s: unexpected content %q", c.UPI, buf)
+		}
+	}
+}
+
+// testFiles gives n files spread over upis UPI. One file out of ten is a
+// duplicate of the previous file of its UPI and one out of fifty is invalid.
+func testFiles(n, upis int) []*File {
+	fs := make([]*File, 0, n)
+	for i := 0; i < n; i++ {
+		var (
+			upi = fmt.Sprintf("UPI%03d", i%upis)
+			seq = uint64(i / upis)
+		)
+		if i%10 == 9 && seq > 0 {
+			seq--
+		}
+		fs = append(fs, testFile(upi, seq, i%50 == 49))
+	}
+	return fs
+}
+
+func TestShardOf(t *testing.T) {
+	for _, f := range testFiles(100, 10) {
+		h := fnv.New32a()
+		io.WriteString(h, f.String())
+		if want, got := int(h.Sum32()%7), shardOf(f, 7); want != got {
+			t.Errorf("%s: want shard %d, got %d", f, want, got)
+		}
+	}
+}
+
+func TestCountFilesShards(t *testing.T) {
+	fs := testFiles(20000, 64)
+	want := countFiles(feedFiles(fs), 1, countOptions{})
+	for _, n := range []int{2, 8, 32} {
+		got := countFiles(feedFiles(fs), n, countOptions{})
+		if len(got) != len(want) {
+			t.Fatalf("%d shards: want %d UPI, got %d", n, len(want), len(got))
+		}
+		for k, w := range want {
+			g, ok := got[k]
+			if !ok {
+				t.Errorf("%d shards: %s not found", n, k)
+				continue
+			}
+			if g.Count != w.Count || g.Uniq != w.Uniq || g.Invalid != w.Invalid || g.Size != w.Size || g.Missing() != w.Missing() || g.First != w.First || g.Last != w.Last {
+				t.Errorf("%d shards: %s: want %+v, got %+v", n, k, *w, *g)
+			}
+		}
+	}
+}
+
+func BenchmarkCountFiles(b *testing.B) {
+	fs := testFiles(100000, 256)
+	// the shards only pay off with several CPU: the dispatch of the files
+	// costs more than their aggregation otherwise.
+	ns := []int{1, 2, 4}
+	if n := runtime.NumCPU(); n > 4 {
+		ns = append(ns, n)
+	}
+	for _, n := range ns {
+		b.Run(fmt.Sprintf("shards-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				countFiles(feedFiles(fs), n, countOptions{})
+			}
+		})
+	}
+}
+
+func TestSampleFiles(t *testing.T) {
+	const (
+		n      = 100000
+		sample = 10
+	)
+	fs := testFiles(n, 4)
+	all := countFiles(feedFiles(fs), 1, countOptions{})
+
+	keep := bySample(sample)
+	var count int
+	for _, f := range fs {
+		if keep(f) != keep(f) {
+			t.Fatalf("%s: sample is not deterministic", f.Path)
+		}
+		if keep(f) {
+			count++
+		}
+	}
+	if want, diff := n/sample, math.Abs(float64(count-n/sample)); diff > n/sample*0.05 {
+		t.Errorf("want about %d files sampled, got %d", want, count)
+	}
+
+	rs := countFiles(filterFiles(feedFiles(fs), keep), 1, countOptions{})
+	scaleCozes(rs, sample)
+	for k, w := range all {
+		g, ok := rs[k]
+		if !ok {
+			t.Errorf("%s: not sampled", k)
+			continue
+		}
+		// the estimates are within 10% of the actual values.
+		for _, v := range []struct {
+			Field     string
+			Want, Got uint64
+		}{
+			{"count", w.Count, g.Count},
+			{"size", w.Size, g.Size},
+		} {
+			if d := math.Abs(float64(v.Got) - float64(v.Want)); d > float64(v.Want)*0.1 {
+				t.Errorf("%s: %s: want about %d, got %d", k, v.Field, v.Want, v.Got)
+			}
+		}
+	}
+}
+
+func TestReportHeader(t *testing.T) {
+	cs := []*Coze{testCoze("AAA", 1, 2, 4), testCoze("BBB", 1, -2, 3)}
+	gs := testGaps("AAA", 1, 2, 5, 6, 9)
+	data := []struct {
+		Name  string
+		Write func(w io.Writer)
+		Cols  []string
+	}{
+		{
+			Name:  "walk",
+			Write: func(w io.Writer) { reportWalkResults(w, cs, walkOptions{CSV: true, Header: true}) },
+			Cols:  walkColumns(walkOptions{}),
+		},
+		{
+			Name: "walk/all",
+			Write: func(w io.Writer) {
+				opts := walkOptions{CSV: true, Header: true, Stored: true, Run: true, Expect: true, Unchecked: map[string]uint64{}}
+				reportWalkResults(w, cs, opts)
+			},
+			Cols: walkColumns(walkOptions{Stored: true, Run: true, Expect: true, Unchecked: map[string]uint64{}}),
+		},
+		{
+			Name: "check",
+			Write: func(w io.Writer) {
+				writeHeader(w, checkColumns(checkOptions{}))
+				reportCheckResults(w, gs, checkOptions{CSV: true})
+			},
+			Cols: checkColumns(checkOptions{}),
+		},
+		{
+			Name: "check/all",
+			Write: func(w io.Writer) {
+				opts := checkOptions{CSV: true, Status: true, Source: true, Sources: true}
+				writeHeader(w, checkColumns(opts))
+				reportCheckResults(w, gs, opts)
+			},
+			Cols: checkColumns(checkOptions{Status: true, Source: true, Sources: true}),
+		},
+	}
+	for _, d := range data {
+		var buf bytes.Buffer
+		d.Write(&buf)
+		rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(rows) < 2 {
+			t.Errorf("%s: want header and rows, got %q", d.Name, buf.String())
+			continue
+		}
+		if got, want := rows[0], strings.Join(d.Cols, ","); got != want {
+			t.Errorf("%s: want header %s, got %s", d.Name, want, got)
+		}
+		for i, r := range rows[1:] {
+			if n := len(strings.Split(r, ",")); n != len(d.Cols) {
+				t.Errorf("%s: row %d: want %d columns, got %d (%s)", d.Name, i+1, len(d.Cols), n, r)
+			}
+		}
+	}
+
+	// without -header, only the rows are written.
+	var buf bytes.Buffer
+	reportWalkResults(&buf, cs, walkOptions{CSV: true})
+	if rows := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(rows) != len(cs) {
+		t.Errorf("without header: want %d rows, got %d", len(cs), len(rows))
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	data := []struct {
+		Group string
+		Time  time.Time
+		Want  string
+	}{
+		{Group: "day", Time: testEpoch, Want: "2019-02-27"},
+		// the periods are in UTC.
+		{Group: "day", Time: time.Date(2019, 2, 28, 0, 30, 0, 0, cet), Want: "2019-02-27"},
+		{Group: "week", Time: testEpoch, Want: "2019-W09"},
+		{Group: "WEEK", Time: time.Date(2019, 12, 30, 12, 0, 0, 0, time.UTC), Want: "2020-W01"},
+		{Group: "week", Time: time.Date(2021, 1, 3, 12, 0, 0, 0, time.UTC), Want: "2020-W53"},
+		{Group: "month", Time: testEpoch, Want: "2019-02"},
+		{Group: "month", Time: time.Date(2019, 3, 1, 0, 30, 0, 0, cet), Want: "2019-02"},
+	}
+	for _, d := range data {
+		group, err := groupBy(d.Group)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Group, err)
+			continue
+		}
+		if got := group(d.Time); got != d.Want {
+			t.Errorf("%s: %s: want %s, got %s", d.Group, d.Time, d.Want, got)
+		}
+	}
+	if group, err := groupBy(""); group != nil || err != nil {
+		t.Errorf("no group: want no period, got %v", err)
+	}
+	if _, err := groupBy("year"); err == nil {
+		t.Errorf("year: want error, got none")
+	}
+
+	// the files of an UPI are counted by week.
+	var fs []*File
+	for i, day := range []int{0, 1, 7, 8, 9, 35} {
+		f := testFile("XYZ", uint64(i+1), false)
+		f.AcqTime = testEpoch.Add(time.Duration(day) * Day)
+		fs = append(fs, f)
+	}
+	group, _ := groupBy("week")
+	rs := countFiles(feedFiles(fs), 1, countOptions{Group: group})
+	want := map[string]uint64{
+		"38/XYZ/2019-W09": 2,
+		"38/XYZ/2019-W10": 3,
+		"38/XYZ/2019-W14": 1,
+	}
+	if len(rs) != len(want) {
+		t.Fatalf("want %d periods, got %d", len(want), len(rs))
+	}
+	for k, n := range want {
+		c, ok := rs[k]
+		if !ok {
+			t.Errorf("%s: period not found", k)
+			continue
+		}
+		if c.Count != n || c.UPI != "38/XYZ" || !strings.HasSuffix(k, "/"+c.Period) {
+			t.Errorf("%s: unexpected count %+v", k, *c)
+		}
+	}
+}
+
+func TestStaleCozes(t *testing.T) {
+	now := testEpoch.Add(time.Hour)
+	var (
+		fresh = testCoze("AAA", 1, 2, 3599)
+		limit = testCoze("BBB", 1, 2, 600)
+		stale = testCoze("CCC", 1, 2)
+		none  = &Coze{UPI: "38/DDD"}
+		gone  = &Coze{UPI: "38/EEE", absent: true}
+	)
+	ages := []struct {
+		Coze *Coze
+		Now  time.Time
+		Want time.Duration
+	}{
+		{Coze: fresh, Now: now, Want: time.Second},
+		{Coze: limit, Now: now, Want: 50 * time.Minute},
+		{Coze: stale, Now: now, Want: time.Hour - 2*time.Second},
+		// file acquired after now: not aged.
+		{Coze: stale, Now: testEpoch, Want: 0},
+		{Coze: none, Now: now, Want: 0},
+	}
+	for _, a := range ages {
+		if got := a.Coze.Age(a.Now); got != a.Want {
+			t.Errorf("%s: want age %s, got %s", a.Coze.UPI, a.Want, got)
+		}
+	}
+
+	cs := []*Coze{fresh, limit, stale, none, gone}
+	data := []struct {
+		Stale time.Duration
+		Want  []string
+	}{
+		{Stale: time.Minute, Want: []string{"38/BBB", "38/CCC", "38/EEE"}},
+		// an UPI exactly as old as -stale is not stale.
+		{Stale: 50 * time.Minute, Want: []string{"38/CCC", "38/EEE"}},
+		{Stale: time.Hour, Want: []string{"38/EEE"}},
+	}
+	for _, d := range data {
+		var got []string
+		for _, c := range staleCozes(cs, now, d.Stale) {
+			got = append(got, c.UPI)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(d.Want) {
+			t.Errorf("stale %s: want %v, got %v", d.Stale, d.Want, got)
+		}
+	}
+
+	// the age is given in seconds in the last column of the csv output.
+	var buf bytes.Buffer
+	reportWalkResults(&buf, []*Coze{limit}, walkOptions{CSV: true, Now: now})
+	vs := strings.Split(strings.TrimSpace(buf.String()), ",")
+	if age := strings.TrimSpace(vs[len(vs)-1]); age != "3000" {
+		t.Errorf("want age 3000, got %s", age)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build xz
+// +build xz
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/ulikunitz/xz"
+)
+
+func init() {
+	decompressors[".txz"] = unxz
+	decompressors[".tar.xz"] = unxz
+}
+
+func unxz(r io.Reader) (io.ReadCloser, error) {
+	z, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(z), nil
+}
@@ -0,0 +1,14 @@
+//go:build xz
+// +build xz
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestScanTarXZ(t *testing.T) {
+	p := filepath.Join("testdata", "archive.tar.xz")
+	checkArchive(t, p, scanArchive(t, p))
+}